@@ -1,6 +1,6 @@
 #!/bin/bash -e
 
-build_targets=(policer)
+build_targets=(policer dnp-webhook)
 BUILD_COMMAND="docker image build"
 TAG_COMMAND="docker image tag"
   
@@ -0,0 +1,113 @@
+package main
+
+import (
+  "bytes"
+  "context"
+  "flag"
+  "io"
+  "log"
+  "os"
+  polv1 "github.com/nokia/danm-utils/crd/api/netpol/v1"
+  "github.com/nokia/danm-utils/pkg/convert"
+  "github.com/nokia/danm-utils/types/poltypes"
+  metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+  "k8s.io/apimachinery/pkg/runtime/schema"
+  "k8s.io/client-go/dynamic"
+  "k8s.io/client-go/tools/clientcmd"
+  clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+var(
+  version, commitHash string
+  networkPolicyResource = schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "networkpolicies"}
+)
+
+//readInput returns the NetworkPolicies either from the given file, or from the cluster when no file is given
+func readInput(fileName, kubeConfig, namespace string, allNamespaces bool) (io.Reader, error) {
+  if fileName == "-" {
+    return os.Stdin, nil
+  } else if fileName != "" {
+    return os.Open(fileName)
+  }
+  loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+  loadingRules.ExplicitPath = kubeConfig
+  clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{Context: clientcmdapi.Context{Namespace: namespace}})
+  if allNamespaces {
+    namespace = metav1.NamespaceAll
+  } else {
+    currentNamespace, _, err := clientConfig.Namespace()
+    if err != nil {
+      return nil, err
+    }
+    namespace = currentNamespace
+  }
+  config, err := clientConfig.ClientConfig()
+  if err != nil {
+    return nil, err
+  }
+  dynamicClient, err := dynamic.NewForConfig(config)
+  if err != nil {
+    return nil, err
+  }
+  //NetworkPolicies are fetched as unstructured objects, so the fields our NetworkPolicy type does not know about are not lost
+  netPolList, err := dynamicClient.Resource(networkPolicyResource).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+  if err != nil {
+    return nil, err
+  }
+  listBytes, err := netPolList.MarshalJSON()
+  if err != nil {
+    return nil, err
+  }
+  return bytes.NewReader(listBytes), nil
+}
+
+func main() {
+  printVersion := flag.Bool("version", false, "prints Git version information of the binary to standard out")
+  fileName := flag.String("f", "", "File containing the NetworkPolicies to convert in YAML, or JSON format. Use - to read standard input. NetworkPolicies are read from the cluster when not given.")
+  kubeConfig := flag.String("kubeconf", "", "Path to a kube config. Defaults to the KUBECONFIG environment variable, or ~/.kube/config.")
+  namespace := flag.String("namespace", "", "Namespace to read the NetworkPolicies from. Defaults to the namespace of the current kube config context.")
+  allNamespaces := flag.Bool("all-namespaces", false, "Read the NetworkPolicies of all namespaces.")
+  networkName := flag.String("network-name", "", "Name of the network injected into the networkSelector of all the converted peers. Peers select all the networks when not given.")
+  networkType := flag.String("network-type", "", "Type of the injected network. Supported values: DanmNet, ClusterNetwork, TenantNetwork.")
+  selectNamespacePods := flag.Bool("select-namespace-pods", false, "Convert peers only having an empty podSelector into a podSelector explicitly selecting all the Pods of the namespace.")
+  flag.Parse()
+  if *printVersion {
+    log.Println("DANM Network Policy converter binary was built from release: " + version)
+    log.Println("DANM Network Policy converter binary was built from commit: " + commitHash)
+    return
+  }
+  log.SetFlags(0)
+  //The converted manifests would be rejected by the admission webhook anyway, so it is better to fail early
+  if *networkType != "" && *networkType != poltypes.DanmNetKind && *networkType != poltypes.ClusterNetworkKind && *networkType != poltypes.TenantNetworkKind {
+    log.Println("ERROR: unsupported network type:" + *networkType + " , supported values are: DanmNet, ClusterNetwork, TenantNetwork, exiting")
+    os.Exit(-1)
+  }
+  opts := convert.Options{SelectNamespacePods: *selectNamespacePods}
+  if *networkName != "" {
+    opts.NetworkSelector = []polv1.NetworkSelector{{Name: *networkName, Type: *networkType}}
+  }
+  input, err := readInput(*fileName, *kubeConfig, *namespace, *allNamespaces)
+  if err != nil {
+    log.Println("ERROR: NetworkPolicies could not be read because:" + err.Error() + " , exiting")
+    os.Exit(-1)
+  }
+  netPols, rawPols, issues, err := convert.DecodeNetworkPolicies(input)
+  if err != nil {
+    log.Println("ERROR: " + err.Error() + " , exiting")
+    os.Exit(-1)
+  }
+  dnps := make([]polv1.DanmNetworkPolicy, 0)
+  for i, netPol := range netPols {
+    dnp, convIssues := convert.ConvertNetworkPolicy(netPol, rawPols[i], opts)
+    issues = append(issues, convIssues...)
+    dnps = append(dnps, dnp)
+  }
+  for _, issue := range issues {
+    log.Println("WARNING: " + issue.String())
+  }
+  err = convert.EncodeDanmNetworkPolicies(os.Stdout, dnps)
+  if err != nil {
+    log.Println("ERROR: " + err.Error() + " , exiting")
+    os.Exit(-1)
+  }
+}
\ No newline at end of file
@@ -0,0 +1,60 @@
+package main
+
+import (
+  "flag"
+  "log"
+  "net/http"
+  "os"
+  danmclientset "github.com/nokia/danm/crd/client/clientset/versioned"
+  "github.com/nokia/danm-utils/pkg/webhook"
+  "k8s.io/client-go/rest"
+  "k8s.io/client-go/tools/clientcmd"
+)
+
+var(
+  version, commitHash string
+)
+
+func getClientConfig(kubeConfig string) (*rest.Config, error) {
+  if kubeConfig != "" {
+    return clientcmd.BuildConfigFromFlags("", kubeConfig)
+  }
+  return rest.InClusterConfig()
+}
+
+func main() {
+  printVersion := flag.Bool("version", false, "prints Git version information of the binary to standard out")
+  kubeConfig := flag.String("kubeconf", "", "Path to a kube config. Only required if out-of-cluster.")
+  address := flag.String("address", ":8443", "Address the webhook server listens on.")
+  certFile := flag.String("tls-cert-file", "/etc/webhook/certs/tls.crt", "Path to the x509 certificate of the webhook server.")
+  keyFile := flag.String("tls-private-key-file", "/etc/webhook/certs/tls.key", "Path to the private key of the webhook server's certificate.")
+  flag.Parse()
+  if *printVersion {
+    log.Println("DANM Network Policy webhook binary was built from release: " + version)
+    log.Println("DANM Network Policy webhook binary was built from commit: " + commitHash)
+    return
+  }
+  log.SetOutput(os.Stdout)
+  log.Println("INFO: Starting DANM Network Policy validating webhook...")
+  config, err := getClientConfig(*kubeConfig)
+  if err != nil {
+    log.Println("ERROR: Parsing kubeconfig failed with error:" + err.Error() + " , exiting")
+    os.Exit(-1)
+  }
+  danmClient, err := danmclientset.NewForConfig(config)
+  if err != nil {
+    log.Println("ERROR: cannot build DANM REST client because:" + err.Error() + " , exiting")
+    os.Exit(-1)
+  }
+  mux := http.NewServeMux()
+  mux.Handle("/validate", webhook.NewValidator(danmClient))
+  mux.HandleFunc("/healthz", func(writer http.ResponseWriter, request *http.Request) {
+    writer.WriteHeader(http.StatusOK)
+  })
+  server := &http.Server{Addr: *address, Handler: mux}
+  err = server.ListenAndServeTLS(*certFile, *keyFile)
+  if err != nil {
+    log.Println("ERROR: DANM Network Policy validating webhook failed with error:" + err.Error() + " , exiting")
+    os.Exit(-1)
+  }
+}
\ No newline at end of file
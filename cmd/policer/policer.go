@@ -22,6 +22,11 @@ func getClientConfig(kubeConfig *string) (*rest.Config, error) {
 
 func main() {
   printVersion := flag.Bool("version", false, "prints Git version information of the binary to standard out")
+  kubeConfig := flag.String("kubeconf", "", "Path to a kube config. Only required if out-of-cluster.")
+  threadiness := flag.Int("threadiness", 5, "Number of Pods the Policer provisions rules into in parallel.")
+  provisioner := flag.String("provisioner", polctrl.IptablesProvisionerName, "Backend used to provision isolation rules into Pods. Supported values: iptables, nftables.")
+  resyncInterval := flag.Duration("resync-interval", 0, "Period of comparing the rules of all local Pods with their policies, and correcting the drifted ones, e.g. 10m. Rules are always resynced once after startup, 0 disables the periodic resync.")
+  mirrorNetPols := flag.Bool("mirror-network-policies", false, "Enforce the upstream NetworkPolicies of the namespaces annotated with danm.k8s.io/mirror-network-policies=true on all the interfaces of their Pods.")
   flag.Parse()
   if *printVersion {
     log.Println("DANM Netpol binary was built from release: " + version)
@@ -30,19 +35,22 @@ func main() {
   }
   log.SetOutput(os.Stdout)
   log.Println("INFO: Starting DANM Network Policy Controller...")
-  kubeConfig := flag.String("kubeconf", "", "Path to a kube config. Only required if out-of-cluster.")
-  flag.Parse()
   config, err := getClientConfig(kubeConfig)
   if err != nil {
     log.Println("ERROR: Parsing kubeconfig failed with error:" + err.Error() + " , exiting")
     os.Exit(-1)
   }
   stopCh := make(chan struct{})
-  netPolicer, err := polctrl.NewNetPolControl(config, &stopCh)
+  ctrlCfg := polctrl.ControllerConfig{RuleProvisioner: *provisioner, MirrorNetworkPolicies: *mirrorNetPols, ResyncInterval: *resyncInterval}
+  netPolicer, err := polctrl.NewNetPolControl(config, ctrlCfg, &stopCh)
   if err != nil {
     log.Println("ERROR: Creation of Network Policy Controller failed with error:" + err.Error() + " , exiting")
     os.Exit(-1)
   }
-  netPolicer.Run()
+  err = netPolicer.Run(*threadiness)
+  if err != nil {
+    log.Println("ERROR: Network Policy Controller failed with error:" + err.Error() + " , exiting")
+    os.Exit(-1)
+  }
   select {}
 }
\ No newline at end of file
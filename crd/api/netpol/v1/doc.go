@@ -2,5 +2,6 @@
 // +k8s:deepcopy-gen=package
 
 // Package v1 is the v1 version of the API.
-// +groupName=netpol.k8s.io
+// +groupName=danm.k8s.io
+// +groupGoName=Netpol
 package v1
@@ -30,6 +30,8 @@ func addKnownTypes(scheme *runtime.Scheme) error {
   scheme.AddKnownTypes(SchemeGroupVersion,
     &DanmNetworkPolicy{},
     &DanmNetworkPolicyList{},
+    &ClusterDanmNetworkPolicy{},
+    &ClusterDanmNetworkPolicyList{},
   )
   meta_v1.AddToGroupVersion(scheme, SchemeGroupVersion)
   return nil
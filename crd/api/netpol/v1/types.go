@@ -1,16 +1,19 @@
 package v1
 
 import (
+  corev1 "k8s.io/api/core/v1"
   metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+  "k8s.io/apimachinery/pkg/util/intstr"
   "k8s.io/kubernetes/pkg/apis/networking"
 )
 
 // +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 type DanmNetworkPolicy struct {
-  metav1.TypeMeta   `json:",inline"`
-  metav1.ObjectMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
-  Spec NetPolSpec   `json:"spec,omitempty" protobuf:"bytes,2,opt,name=spec"`
+  metav1.TypeMeta     `json:",inline"`
+  metav1.ObjectMeta   `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
+  Spec   NetPolSpec   `json:"spec,omitempty" protobuf:"bytes,2,opt,name=spec"`
+  Status NetPolStatus `json:"status,omitempty" protobuf:"bytes,3,opt,name=status"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -20,30 +23,132 @@ type DanmNetworkPolicyList struct {
   Items           []DanmNetworkPolicy `json:"items"`
 }
 
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+//ClusterDanmNetworkPolicy is the cluster-scoped variant of DanmNetworkPolicy, used to provision baseline rules into all, or multiple namespaces
+type ClusterDanmNetworkPolicy struct {
+  metav1.TypeMeta      `json:",inline"`
+  metav1.ObjectMeta    `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
+  Spec ClusterNetPolSpec `json:"spec,omitempty" protobuf:"bytes,2,opt,name=spec"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type ClusterDanmNetworkPolicyList struct {
+  metav1.TypeMeta `json:",inline"`
+  metav1.ListMeta `json:"metadata"`
+  Items           []ClusterDanmNetworkPolicy `json:"items"`
+}
+
+//ClusterNetPolSpec selects its subject Pods from the namespaces matching its NamespaceSelector
+//An empty NamespaceSelector selects all namespaces
+type ClusterNetPolSpec struct {
+  NamespaceSelector metav1.LabelSelector `json:"namespaceSelector" protobuf:"bytes,1,opt,name=namespaceSelector"`
+  NetPolSpec        `json:",inline" protobuf:"bytes,2,opt,name=netPolSpec"`
+}
+
 type NetPolSpec struct {
-  PodSelector metav1.LabelSelector        `json:"podSelector" protobuf:"bytes,1,opt,name=podSelector"`
-  Ingress     []NetworkPolicyIngressRule  `json:"ingress,omitempty" protobuf:"bytes,2,rep,name=ingress"`
-  Egress      []NetworkPolicyEgressRule   `json:"egress,omitempty" protobuf:"bytes,3,rep,name=egress"`
-  PolicyTypes []networking.PolicyType     `json:"policyTypes,omitempty" protobuf:"bytes,4,rep,name=policyTypes"`
+  PodSelector           metav1.LabelSelector       `json:"podSelector" protobuf:"bytes,1,opt,name=podSelector"`
+  Ingress               []NetworkPolicyIngressRule `json:"ingress,omitempty" protobuf:"bytes,2,rep,name=ingress"`
+  Egress                []NetworkPolicyEgressRule  `json:"egress,omitempty" protobuf:"bytes,3,rep,name=egress"`
+  PolicyTypes           []networking.PolicyType    `json:"policyTypes,omitempty" protobuf:"bytes,4,rep,name=policyTypes"`
+  //TargetNetworkSelector restricts the isolation to those interfaces of the selected Pods which are connected to any of the selected networks
+  //All interfaces of the selected Pods are isolated when it is omitted
+  TargetNetworkSelector []NetworkSelector          `json:"targetNetworkSelector,omitempty" protobuf:"bytes,5,rep,name=targetNetworkSelector"`
+  //Priority orders the rules of the policies selecting the same Pod. Rules of policies with lower values are evaluated first
+  //Rules of policies with the same priority are evaluated in an unspecified order
+  Priority              int32                      `json:"priority,omitempty" protobuf:"varint,6,opt,name=priority"`
 }
 
+//RuleAction tells what happens with the traffic matching a rule
+type RuleAction string
+
+const (
+  //RuleActionAllow whitelists the matching traffic. It is the default action of a rule
+  RuleActionAllow RuleAction = "Allow"
+  //RuleActionDeny rejects the matching traffic, even if it is whitelisted by a rule evaluated later
+  RuleActionDeny  RuleAction = "Deny"
+)
+
 type NetworkPolicyIngressRule struct {
-  Ports []networking.NetworkPolicyPort `json:"ports,omitempty" protobuf:"bytes,1,rep,name=ports"`
-  From  []NetworkPolicyPeer            `json:"from,omitempty" protobuf:"bytes,2,rep,name=from"`
+  Ports  []NetworkPolicyPort `json:"ports,omitempty" protobuf:"bytes,1,rep,name=ports"`
+  From   []NetworkPolicyPeer `json:"from,omitempty" protobuf:"bytes,2,rep,name=from"`
+  Action RuleAction          `json:"action,omitempty" protobuf:"bytes,3,opt,name=action,casttype=RuleAction"`
 }
 
 type NetworkPolicyEgressRule struct {
-  Ports []networking.NetworkPolicyPort `json:"ports,omitempty" protobuf:"bytes,1,rep,name=ports"`
-  To    []NetworkPolicyPeer            `json:"to,omitempty" protobuf:"bytes,2,rep,name=to"`
+  Ports  []NetworkPolicyPort `json:"ports,omitempty" protobuf:"bytes,1,rep,name=ports"`
+  To     []NetworkPolicyPeer `json:"to,omitempty" protobuf:"bytes,2,rep,name=to"`
+  Action RuleAction          `json:"action,omitempty" protobuf:"bytes,3,opt,name=action,casttype=RuleAction"`
+}
+
+//NetworkPolicyPort is the upstream NetworkPolicyPort extended with an optional EndPort
+//When EndPort is set the rule whitelists the whole Port-EndPort range. It can only be used together with a numeric Port
+type NetworkPolicyPort struct {
+  Protocol *corev1.Protocol    `json:"protocol,omitempty" protobuf:"bytes,1,opt,name=protocol,casttype=k8s.io/api/core/v1.Protocol"`
+  Port     *intstr.IntOrString `json:"port,omitempty" protobuf:"bytes,2,opt,name=port"`
+  EndPort  *int32              `json:"endPort,omitempty" protobuf:"bytes,3,opt,name=endPort"`
 }
 
 type NetworkPolicyPeer struct {
-  PodSelector       metav1.LabelSelector `json:"podSelector,omitempty" protobuf:"bytes,1,opt,name=podSelector"`
-  NamespaceSelector metav1.LabelSelector `json:"namespaceSelector,omitempty" protobuf:"bytes,2,opt,name=namespaceSelector"`
-  NetworkSelector   []NetworkSelector    `json:"networkSelector,omitempty" protobuf:"bytes,3,opt,name=networkSelector"`
+  PodSelector       metav1.LabelSelector  `json:"podSelector,omitempty" protobuf:"bytes,1,opt,name=podSelector"`
+  NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty" protobuf:"bytes,2,opt,name=namespaceSelector"`
+  NetworkSelector   []NetworkSelector     `json:"networkSelector,omitempty" protobuf:"bytes,3,opt,name=networkSelector"`
+  IPBlock           *IPBlock              `json:"ipBlock,omitempty" protobuf:"bytes,4,opt,name=ipBlock"`
+}
+
+type IPBlock struct {
+  CIDR   string   `json:"cidr" protobuf:"bytes,1,name=cidr"`
+  Except []string `json:"except,omitempty" protobuf:"bytes,2,rep,name=except"`
 }
 
 type NetworkSelector struct {
   Name  string `json:"name,omitempty" protobuf:"bytes,1,opt,name=name"`
   Type  string `json:"type,omitempty" protobuf:"bytes,2,opt,name=type"`
+}
+
+//NetPolStatus tells whether the policy could be parsed, and how it was provisioned into the selected Pods on the different nodes
+type NetPolStatus struct {
+  //ObservedGeneration is the generation of the policy the status was calculated from
+  ObservedGeneration int64             `json:"observedGeneration,omitempty" protobuf:"varint,1,opt,name=observedGeneration"`
+  Conditions         []NetPolCondition `json:"conditions,omitempty" protobuf:"bytes,2,rep,name=conditions"`
+  //SelectedPods is the number of Pods selected by the policy on all the nodes
+  SelectedPods       int32             `json:"selectedPods" protobuf:"varint,3,opt,name=selectedPods"`
+  //Nodes contains the provisioning results reported by the Policer instances of the nodes hosting any of the selected Pods
+  Nodes              []NodeStatus      `json:"nodes,omitempty" protobuf:"bytes,4,rep,name=nodes"`
+}
+
+type NetPolConditionType string
+
+const (
+  //NetPolParsed is True when all the selectors, ports, and peers of the policy are valid
+  NetPolParsed      NetPolConditionType = "Parsed"
+  //NetPolProvisioned is True when the rules of the policy were successfully provisioned into all the selected Pods
+  NetPolProvisioned NetPolConditionType = "Provisioned"
+)
+
+const (
+  ReasonValid       = "Valid"
+  ReasonInvalid     = "Invalid"
+  ReasonProvisioned = "Provisioned"
+  ReasonFailed      = "Failed"
+)
+
+type NetPolCondition struct {
+  Type               NetPolConditionType    `json:"type" protobuf:"bytes,1,opt,name=type,casttype=NetPolConditionType"`
+  Status             corev1.ConditionStatus `json:"status" protobuf:"bytes,2,opt,name=status,casttype=k8s.io/api/core/v1.ConditionStatus"`
+  LastTransitionTime metav1.Time            `json:"lastTransitionTime,omitempty" protobuf:"bytes,3,opt,name=lastTransitionTime"`
+  Reason             string                 `json:"reason,omitempty" protobuf:"bytes,4,opt,name=reason"`
+  Message            string                 `json:"message,omitempty" protobuf:"bytes,5,opt,name=message"`
+}
+
+//NodeStatus is the provisioning result of the policy on one node
+type NodeStatus struct {
+  NodeName        string      `json:"nodeName" protobuf:"bytes,1,opt,name=nodeName"`
+  SelectedPods    int32       `json:"selectedPods" protobuf:"varint,2,opt,name=selectedPods"`
+  ProvisionedPods int32       `json:"provisionedPods" protobuf:"varint,3,opt,name=provisionedPods"`
+  FailedPods      int32       `json:"failedPods" protobuf:"varint,4,opt,name=failedPods"`
+  //Message contains the error of one of the Pods the rules could not be provisioned into
+  Message         string      `json:"message,omitempty" protobuf:"bytes,5,opt,name=message"`
+  LastUpdateTime  metav1.Time `json:"lastUpdateTime,omitempty" protobuf:"bytes,6,opt,name=lastUpdateTime"`
 }
\ No newline at end of file
@@ -0,0 +1,168 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	"context"
+	"time"
+
+	v1 "github.com/nokia/danm-utils/crd/api/netpol/v1"
+	scheme "github.com/nokia/danm-utils/crd/client/clientset/versioned/scheme"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// ClusterDanmNetworkPoliciesGetter has a method to return a ClusterDanmNetworkPolicyInterface.
+// A group's client should implement this interface.
+type ClusterDanmNetworkPoliciesGetter interface {
+	ClusterDanmNetworkPolicies() ClusterDanmNetworkPolicyInterface
+}
+
+// ClusterDanmNetworkPolicyInterface has methods to work with ClusterDanmNetworkPolicy resources.
+type ClusterDanmNetworkPolicyInterface interface {
+	Create(ctx context.Context, clusterDanmNetworkPolicy *v1.ClusterDanmNetworkPolicy, opts metav1.CreateOptions) (*v1.ClusterDanmNetworkPolicy, error)
+	Update(ctx context.Context, clusterDanmNetworkPolicy *v1.ClusterDanmNetworkPolicy, opts metav1.UpdateOptions) (*v1.ClusterDanmNetworkPolicy, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1.ClusterDanmNetworkPolicy, error)
+	List(ctx context.Context, opts metav1.ListOptions) (*v1.ClusterDanmNetworkPolicyList, error)
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (result *v1.ClusterDanmNetworkPolicy, err error)
+	ClusterDanmNetworkPolicyExpansion
+}
+
+// clusterDanmNetworkPolicies implements ClusterDanmNetworkPolicyInterface
+type clusterDanmNetworkPolicies struct {
+	client rest.Interface
+}
+
+// newClusterDanmNetworkPolicies returns a ClusterDanmNetworkPolicies
+func newClusterDanmNetworkPolicies(c *NetpolV1Client) *clusterDanmNetworkPolicies {
+	return &clusterDanmNetworkPolicies{
+		client: c.RESTClient(),
+	}
+}
+
+// Get takes name of the clusterDanmNetworkPolicy, and returns the corresponding clusterDanmNetworkPolicy object, and an error if there is any.
+func (c *clusterDanmNetworkPolicies) Get(ctx context.Context, name string, options metav1.GetOptions) (result *v1.ClusterDanmNetworkPolicy, err error) {
+	result = &v1.ClusterDanmNetworkPolicy{}
+	err = c.client.Get().
+		Resource("clusterdanmnetworkpolicies").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of ClusterDanmNetworkPolicies that match those selectors.
+func (c *clusterDanmNetworkPolicies) List(ctx context.Context, opts metav1.ListOptions) (result *v1.ClusterDanmNetworkPolicyList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	result = &v1.ClusterDanmNetworkPolicyList{}
+	err = c.client.Get().
+		Resource("clusterdanmnetworkpolicies").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested clusterDanmNetworkPolicies.
+func (c *clusterDanmNetworkPolicies) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	opts.Watch = true
+	return c.client.Get().
+		Resource("clusterdanmnetworkpolicies").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Watch(ctx)
+}
+
+// Create takes the representation of a clusterDanmNetworkPolicy and creates it.  Returns the server's representation of the clusterDanmNetworkPolicy, and an error, if there is any.
+func (c *clusterDanmNetworkPolicies) Create(ctx context.Context, clusterDanmNetworkPolicy *v1.ClusterDanmNetworkPolicy, opts metav1.CreateOptions) (result *v1.ClusterDanmNetworkPolicy, err error) {
+	result = &v1.ClusterDanmNetworkPolicy{}
+	err = c.client.Post().
+		Resource("clusterdanmnetworkpolicies").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(clusterDanmNetworkPolicy).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Update takes the representation of a clusterDanmNetworkPolicy and updates it. Returns the server's representation of the clusterDanmNetworkPolicy, and an error, if there is any.
+func (c *clusterDanmNetworkPolicies) Update(ctx context.Context, clusterDanmNetworkPolicy *v1.ClusterDanmNetworkPolicy, opts metav1.UpdateOptions) (result *v1.ClusterDanmNetworkPolicy, err error) {
+	result = &v1.ClusterDanmNetworkPolicy{}
+	err = c.client.Put().
+		Resource("clusterdanmnetworkpolicies").
+		Name(clusterDanmNetworkPolicy.Name).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(clusterDanmNetworkPolicy).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Delete takes name of the clusterDanmNetworkPolicy and deletes it. Returns an error if one occurs.
+func (c *clusterDanmNetworkPolicies) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return c.client.Delete().
+		Resource("clusterdanmnetworkpolicies").
+		Name(name).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *clusterDanmNetworkPolicies) DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error {
+	var timeout time.Duration
+	if listOpts.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOpts.TimeoutSeconds) * time.Second
+	}
+	return c.client.Delete().
+		Resource("clusterdanmnetworkpolicies").
+		VersionedParams(&listOpts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// Patch applies the patch and returns the patched clusterDanmNetworkPolicy.
+func (c *clusterDanmNetworkPolicies) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (result *v1.ClusterDanmNetworkPolicy, err error) {
+	result = &v1.ClusterDanmNetworkPolicy{}
+	err = c.client.Patch(pt).
+		Resource("clusterdanmnetworkpolicies").
+		Name(name).
+		SubResource(subresources...).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(data).
+		Do(ctx).
+		Into(result)
+	return
+}
@@ -40,6 +40,7 @@ type DanmNetworkPoliciesGetter interface {
 type DanmNetworkPolicyInterface interface {
 	Create(ctx context.Context, danmNetworkPolicy *v1.DanmNetworkPolicy, opts metav1.CreateOptions) (*v1.DanmNetworkPolicy, error)
 	Update(ctx context.Context, danmNetworkPolicy *v1.DanmNetworkPolicy, opts metav1.UpdateOptions) (*v1.DanmNetworkPolicy, error)
+	UpdateStatus(ctx context.Context, danmNetworkPolicy *v1.DanmNetworkPolicy, opts metav1.UpdateOptions) (*v1.DanmNetworkPolicy, error)
 	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
 	DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error
 	Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1.DanmNetworkPolicy, error)
@@ -135,6 +136,22 @@ func (c *danmNetworkPolicies) Update(ctx context.Context, danmNetworkPolicy *v1.
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *danmNetworkPolicies) UpdateStatus(ctx context.Context, danmNetworkPolicy *v1.DanmNetworkPolicy, opts metav1.UpdateOptions) (result *v1.DanmNetworkPolicy, err error) {
+	result = &v1.DanmNetworkPolicy{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("danmnetworkpolicies").
+		Name(danmNetworkPolicy.Name).
+		SubResource("status").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(danmNetworkPolicy).
+		Do(ctx).
+		Into(result)
+	return
+}
+
 // Delete takes name of the danmNetworkPolicy and deletes it. Returns an error if one occurs.
 func (c *danmNetworkPolicies) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
 	return c.client.Delete().
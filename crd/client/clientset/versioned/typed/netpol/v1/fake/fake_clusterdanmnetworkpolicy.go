@@ -0,0 +1,122 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	"context"
+
+	netpolv1 "github.com/nokia/danm-utils/crd/api/netpol/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeClusterDanmNetworkPolicies implements ClusterDanmNetworkPolicyInterface
+type FakeClusterDanmNetworkPolicies struct {
+	Fake *FakeNetpolV1
+}
+
+var clusterdanmnetworkpoliciesResource = schema.GroupVersionResource{Group: "danm.k8s.io", Version: "v1", Resource: "clusterdanmnetworkpolicies"}
+
+var clusterdanmnetworkpoliciesKind = schema.GroupVersionKind{Group: "danm.k8s.io", Version: "v1", Kind: "ClusterDanmNetworkPolicy"}
+
+// Get takes name of the clusterDanmNetworkPolicy, and returns the corresponding clusterDanmNetworkPolicy object, and an error if there is any.
+func (c *FakeClusterDanmNetworkPolicies) Get(ctx context.Context, name string, options v1.GetOptions) (result *netpolv1.ClusterDanmNetworkPolicy, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootGetAction(clusterdanmnetworkpoliciesResource, name), &netpolv1.ClusterDanmNetworkPolicy{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*netpolv1.ClusterDanmNetworkPolicy), err
+}
+
+// List takes label and field selectors, and returns the list of ClusterDanmNetworkPolicies that match those selectors.
+func (c *FakeClusterDanmNetworkPolicies) List(ctx context.Context, opts v1.ListOptions) (result *netpolv1.ClusterDanmNetworkPolicyList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootListAction(clusterdanmnetworkpoliciesResource, clusterdanmnetworkpoliciesKind, opts), &netpolv1.ClusterDanmNetworkPolicyList{})
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &netpolv1.ClusterDanmNetworkPolicyList{ListMeta: obj.(*netpolv1.ClusterDanmNetworkPolicyList).ListMeta}
+	for _, item := range obj.(*netpolv1.ClusterDanmNetworkPolicyList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested clusterDanmNetworkPolicies.
+func (c *FakeClusterDanmNetworkPolicies) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewRootWatchAction(clusterdanmnetworkpoliciesResource, opts))
+}
+
+// Create takes the representation of a clusterDanmNetworkPolicy and creates it.  Returns the server's representation of the clusterDanmNetworkPolicy, and an error, if there is any.
+func (c *FakeClusterDanmNetworkPolicies) Create(ctx context.Context, clusterDanmNetworkPolicy *netpolv1.ClusterDanmNetworkPolicy, opts v1.CreateOptions) (result *netpolv1.ClusterDanmNetworkPolicy, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootCreateAction(clusterdanmnetworkpoliciesResource, clusterDanmNetworkPolicy), &netpolv1.ClusterDanmNetworkPolicy{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*netpolv1.ClusterDanmNetworkPolicy), err
+}
+
+// Update takes the representation of a clusterDanmNetworkPolicy and updates it. Returns the server's representation of the clusterDanmNetworkPolicy, and an error, if there is any.
+func (c *FakeClusterDanmNetworkPolicies) Update(ctx context.Context, clusterDanmNetworkPolicy *netpolv1.ClusterDanmNetworkPolicy, opts v1.UpdateOptions) (result *netpolv1.ClusterDanmNetworkPolicy, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootUpdateAction(clusterdanmnetworkpoliciesResource, clusterDanmNetworkPolicy), &netpolv1.ClusterDanmNetworkPolicy{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*netpolv1.ClusterDanmNetworkPolicy), err
+}
+
+// Delete takes name of the clusterDanmNetworkPolicy and deletes it. Returns an error if one occurs.
+func (c *FakeClusterDanmNetworkPolicies) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewRootDeleteAction(clusterdanmnetworkpoliciesResource, name), &netpolv1.ClusterDanmNetworkPolicy{})
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeClusterDanmNetworkPolicies) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	action := testing.NewRootDeleteCollectionAction(clusterdanmnetworkpoliciesResource, listOpts)
+
+	_, err := c.Fake.Invokes(action, &netpolv1.ClusterDanmNetworkPolicyList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched clusterDanmNetworkPolicy.
+func (c *FakeClusterDanmNetworkPolicies) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *netpolv1.ClusterDanmNetworkPolicy, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootPatchSubresourceAction(clusterdanmnetworkpoliciesResource, name, pt, data, subresources...), &netpolv1.ClusterDanmNetworkPolicy{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*netpolv1.ClusterDanmNetworkPolicy), err
+}
@@ -36,9 +36,9 @@ type FakeDanmNetworkPolicies struct {
 	ns   string
 }
 
-var danmnetworkpoliciesResource = schema.GroupVersionResource{Group: "netpol.k8s.io", Version: "v1", Resource: "danmnetworkpolicies"}
+var danmnetworkpoliciesResource = schema.GroupVersionResource{Group: "danm.k8s.io", Version: "v1", Resource: "danmnetworkpolicies"}
 
-var danmnetworkpoliciesKind = schema.GroupVersionKind{Group: "netpol.k8s.io", Version: "v1", Kind: "DanmNetworkPolicy"}
+var danmnetworkpoliciesKind = schema.GroupVersionKind{Group: "danm.k8s.io", Version: "v1", Kind: "DanmNetworkPolicy"}
 
 // Get takes name of the danmNetworkPolicy, and returns the corresponding danmNetworkPolicy object, and an error if there is any.
 func (c *FakeDanmNetworkPolicies) Get(ctx context.Context, name string, options v1.GetOptions) (result *netpolv1.DanmNetworkPolicy, err error) {
@@ -102,6 +102,18 @@ func (c *FakeDanmNetworkPolicies) Update(ctx context.Context, danmNetworkPolicy
 	return obj.(*netpolv1.DanmNetworkPolicy), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeDanmNetworkPolicies) UpdateStatus(ctx context.Context, danmNetworkPolicy *netpolv1.DanmNetworkPolicy, opts v1.UpdateOptions) (*netpolv1.DanmNetworkPolicy, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(danmnetworkpoliciesResource, "status", c.ns, danmNetworkPolicy), &netpolv1.DanmNetworkPolicy{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*netpolv1.DanmNetworkPolicy), err
+}
+
 // Delete takes name of the danmNetworkPolicy and deletes it. Returns an error if one occurs.
 func (c *FakeDanmNetworkPolicies) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
 	_, err := c.Fake.
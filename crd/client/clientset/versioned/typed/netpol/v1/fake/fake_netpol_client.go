@@ -28,6 +28,10 @@ type FakeNetpolV1 struct {
 	*testing.Fake
 }
 
+func (c *FakeNetpolV1) ClusterDanmNetworkPolicies() v1.ClusterDanmNetworkPolicyInterface {
+	return &FakeClusterDanmNetworkPolicies{c}
+}
+
 func (c *FakeNetpolV1) DanmNetworkPolicies(namespace string) v1.DanmNetworkPolicyInterface {
 	return &FakeDanmNetworkPolicies{c, namespace}
 }
@@ -26,14 +26,19 @@ import (
 
 type NetpolV1Interface interface {
 	RESTClient() rest.Interface
+	ClusterDanmNetworkPoliciesGetter
 	DanmNetworkPoliciesGetter
 }
 
-// NetpolV1Client is used to interact with features provided by the netpol.k8s.io group.
+// NetpolV1Client is used to interact with features provided by the danm.k8s.io group.
 type NetpolV1Client struct {
 	restClient rest.Interface
 }
 
+func (c *NetpolV1Client) ClusterDanmNetworkPolicies() ClusterDanmNetworkPolicyInterface {
+	return newClusterDanmNetworkPolicies(c)
+}
+
 func (c *NetpolV1Client) DanmNetworkPolicies(namespace string) DanmNetworkPolicyInterface {
 	return newDanmNetworkPolicies(c, namespace)
 }
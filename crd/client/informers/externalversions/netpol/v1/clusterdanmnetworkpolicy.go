@@ -0,0 +1,89 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	"context"
+	time "time"
+
+	netpolv1 "github.com/nokia/danm-utils/crd/api/netpol/v1"
+	versioned "github.com/nokia/danm-utils/crd/client/clientset/versioned"
+	internalinterfaces "github.com/nokia/danm-utils/crd/client/informers/externalversions/internalinterfaces"
+	v1 "github.com/nokia/danm-utils/crd/client/listers/netpol/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// ClusterDanmNetworkPolicyInformer provides access to a shared informer and lister for
+// ClusterDanmNetworkPolicies.
+type ClusterDanmNetworkPolicyInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() v1.ClusterDanmNetworkPolicyLister
+}
+
+type clusterDanmNetworkPolicyInformer struct {
+	factory          internalinterfaces.SharedInformerFactory
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+}
+
+// NewClusterDanmNetworkPolicyInformer constructs a new informer for ClusterDanmNetworkPolicy type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewClusterDanmNetworkPolicyInformer(client versioned.Interface, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return NewFilteredClusterDanmNetworkPolicyInformer(client, resyncPeriod, indexers, nil)
+}
+
+// NewFilteredClusterDanmNetworkPolicyInformer constructs a new informer for ClusterDanmNetworkPolicy type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewFilteredClusterDanmNetworkPolicyInformer(client versioned.Interface, resyncPeriod time.Duration, indexers cache.Indexers, tweakListOptions internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.NetpolV1().ClusterDanmNetworkPolicies().List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.NetpolV1().ClusterDanmNetworkPolicies().Watch(context.TODO(), options)
+			},
+		},
+		&netpolv1.ClusterDanmNetworkPolicy{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+func (f *clusterDanmNetworkPolicyInformer) defaultInformer(client versioned.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return NewFilteredClusterDanmNetworkPolicyInformer(client, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+}
+
+func (f *clusterDanmNetworkPolicyInformer) Informer() cache.SharedIndexInformer {
+	return f.factory.InformerFor(&netpolv1.ClusterDanmNetworkPolicy{}, f.defaultInformer)
+}
+
+func (f *clusterDanmNetworkPolicyInformer) Lister() v1.ClusterDanmNetworkPolicyLister {
+	return v1.NewClusterDanmNetworkPolicyLister(f.Informer().GetIndexer())
+}
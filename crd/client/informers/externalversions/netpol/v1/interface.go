@@ -24,6 +24,8 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// ClusterDanmNetworkPolicies returns a ClusterDanmNetworkPolicyInformer.
+	ClusterDanmNetworkPolicies() ClusterDanmNetworkPolicyInformer
 	// DanmNetworkPolicies returns a DanmNetworkPolicyInformer.
 	DanmNetworkPolicies() DanmNetworkPolicyInformer
 }
@@ -39,6 +41,11 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// ClusterDanmNetworkPolicies returns a ClusterDanmNetworkPolicyInformer.
+func (v *version) ClusterDanmNetworkPolicies() ClusterDanmNetworkPolicyInformer {
+	return &clusterDanmNetworkPolicyInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
+
 // DanmNetworkPolicies returns a DanmNetworkPolicyInformer.
 func (v *version) DanmNetworkPolicies() DanmNetworkPolicyInformer {
 	return &danmNetworkPolicyInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
@@ -0,0 +1,65 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	v1 "github.com/nokia/danm-utils/crd/api/netpol/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ClusterDanmNetworkPolicyLister helps list ClusterDanmNetworkPolicies.
+type ClusterDanmNetworkPolicyLister interface {
+	// List lists all ClusterDanmNetworkPolicies in the indexer.
+	List(selector labels.Selector) (ret []*v1.ClusterDanmNetworkPolicy, err error)
+	// Get retrieves the ClusterDanmNetworkPolicy from the index for a given name.
+	Get(name string) (*v1.ClusterDanmNetworkPolicy, error)
+	ClusterDanmNetworkPolicyListerExpansion
+}
+
+// clusterDanmNetworkPolicyLister implements the ClusterDanmNetworkPolicyLister interface.
+type clusterDanmNetworkPolicyLister struct {
+	indexer cache.Indexer
+}
+
+// NewClusterDanmNetworkPolicyLister returns a new ClusterDanmNetworkPolicyLister.
+func NewClusterDanmNetworkPolicyLister(indexer cache.Indexer) ClusterDanmNetworkPolicyLister {
+	return &clusterDanmNetworkPolicyLister{indexer: indexer}
+}
+
+// List lists all ClusterDanmNetworkPolicies in the indexer.
+func (s *clusterDanmNetworkPolicyLister) List(selector labels.Selector) (ret []*v1.ClusterDanmNetworkPolicy, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1.ClusterDanmNetworkPolicy))
+	})
+	return ret, err
+}
+
+// Get retrieves the ClusterDanmNetworkPolicy from the index for a given name.
+func (s *clusterDanmNetworkPolicyLister) Get(name string) (*v1.ClusterDanmNetworkPolicy, error) {
+	obj, exists, err := s.indexer.GetByKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1.Resource("clusterdanmnetworkpolicy"), name)
+	}
+	return obj.(*v1.ClusterDanmNetworkPolicy), nil
+}
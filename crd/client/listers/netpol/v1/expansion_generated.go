@@ -18,6 +18,10 @@ limitations under the License.
 
 package v1
 
+// ClusterDanmNetworkPolicyListerExpansion allows custom methods to be added to
+// ClusterDanmNetworkPolicyLister.
+type ClusterDanmNetworkPolicyListerExpansion interface{}
+
 // DanmNetworkPolicyListerExpansion allows custom methods to be added to
 // DanmNetworkPolicyLister.
 type DanmNetworkPolicyListerExpansion interface{}
@@ -11,6 +11,7 @@ require (
 	k8s.io/code-generator v0.18.3
 	k8s.io/kubernetes v1.19.0-beta.0
 	k8s.io/utils v0.0.0-20200414100711-2df71ebbae66
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace (
@@ -0,0 +1,361 @@
+package convert
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "io"
+  "sort"
+  "strconv"
+  "strings"
+  polv1 "github.com/nokia/danm-utils/crd/api/netpol/v1"
+  networkingv1 "k8s.io/api/networking/v1"
+  metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+  "k8s.io/apimachinery/pkg/util/intstr"
+  utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+  "k8s.io/kubernetes/pkg/apis/networking"
+  "sigs.k8s.io/yaml"
+)
+
+const (
+  NetworkPolicyKind = "NetworkPolicy"
+  NetworkPolicyListKind = "NetworkPolicyList"
+  ListKind = "List"
+  DanmNetworkPolicyKind = "DanmNetworkPolicy"
+  //LastAppliedAnnotation is created by kubectl apply, it would only confuse kubectl when applying the converted manifest
+  LastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+  //NamespacePodsSelectorKey is a label key no Pod is expected to have, so a DoesNotExist expression on it selects all the Pods
+  NamespacePodsSelectorKey = "danm.k8s.io/no-such-label"
+  //decoderBufferSize is the number of bytes the decoder reads ahead to decide whether the input is YAML, or JSON
+  decoderBufferSize = 4096
+)
+
+//Options tunes how the NetworkPolicies are converted
+type Options struct {
+  //NetworkSelector is injected into all the peers having any selectors, so the converted policies only whitelist the peers on the selected networks
+  //Peers are left untouched when it is empty, i.e. they select all the interfaces of the peer Pods
+  NetworkSelector     []polv1.NetworkSelector
+  //SelectNamespacePods translates peers only having an empty podSelector into a podSelector explicitly matching all the Pods of the namespace
+  //Such peers are only reported when it is not set
+  SelectNamespacePods bool
+}
+
+//Issue is a construct of a NetworkPolicy which could not be translated into its DanmNetworkPolicy
+type Issue struct {
+  //Policy is the namespace/name of the NetworkPolicy
+  Policy  string
+  //Field is the path of the construct within the NetworkPolicy
+  Field   string
+  Message string
+}
+
+func (issue Issue) String() string {
+  return issue.Policy + ": " + issue.Field + ": " + issue.Message
+}
+
+//DecodeNetworkPolicies reads all the NetworkPolicies from a YAML, or JSON stream. Streams can contain multiple documents, and lists of NetworkPolicies
+//Fields of the NetworkPolicies which are unknown to the converter are returned as issues, except endPort which is translated too
+//The returned raw objects are to be passed to ConvertNetworkPolicy together with their NetworkPolicy
+func DecodeNetworkPolicies(reader io.Reader) ([]networkingv1.NetworkPolicy, []map[string]interface{}, []Issue, error) {
+  netPols := make([]networkingv1.NetworkPolicy, 0)
+  rawPols := make([]map[string]interface{}, 0)
+  issues := make([]Issue, 0)
+  decoder := utilyaml.NewYAMLOrJSONDecoder(reader, decoderBufferSize)
+  for {
+    var rawObject map[string]interface{}
+    err := decoder.Decode(&rawObject)
+    if err == io.EOF {
+      break
+    } else if err != nil {
+      return nil, nil, nil, errors.New("input could not be decoded because:" + err.Error())
+    }
+    if rawObject == nil {
+      continue
+    }
+    rawObjects := []map[string]interface{}{rawObject}
+    if kind, _ := rawObject["kind"].(string); kind == NetworkPolicyListKind || kind == ListKind {
+      rawObjects = getListItems(rawObject)
+    }
+    for _, rawPol := range rawObjects {
+      if kind, _ := rawPol["kind"].(string); kind != NetworkPolicyKind {
+        issues = append(issues, Issue{Policy: getRawName(rawPol), Field: "kind", Message: "object of kind:" + kind + " is not a NetworkPolicy, skipping it"})
+        continue
+      }
+      netPol, unknownFields, err := decodeNetworkPolicy(rawPol)
+      if err != nil {
+        return nil, nil, nil, errors.New("NetworkPolicy:" + getRawName(rawPol) + " could not be decoded because:" + err.Error())
+      }
+      for _, unknownField := range unknownFields {
+        issues = append(issues, Issue{Policy: getPolicyKey(netPol), Field: unknownField, Message: "field is not supported by DanmNetworkPolicy, it is left out"})
+      }
+      netPols = append(netPols, netPol)
+      rawPols = append(rawPols, rawPol)
+    }
+  }
+  return netPols, rawPols, issues, nil
+}
+
+func getListItems(rawList map[string]interface{}) []map[string]interface{} {
+  rawObjects := make([]map[string]interface{}, 0)
+  items, _ := rawList["items"].([]interface{})
+  for _, item := range items {
+    if rawObject, ok := item.(map[string]interface{}); ok {
+      rawObjects = append(rawObjects, rawObject)
+    }
+  }
+  return rawObjects
+}
+
+func getRawName(rawObject map[string]interface{}) string {
+  metadata, _ := rawObject["metadata"].(map[string]interface{})
+  namespace, _ := metadata["namespace"].(string)
+  name, _ := metadata["name"].(string)
+  return namespace + "/" + name
+}
+
+func getPolicyKey(netPol networkingv1.NetworkPolicy) string {
+  return netPol.ObjectMeta.Namespace + "/" + netPol.ObjectMeta.Name
+}
+
+//decodeNetworkPolicy decodes the raw object, and returns the paths of the fields which got lost during decoding
+func decodeNetworkPolicy(rawPol map[string]interface{}) (networkingv1.NetworkPolicy, []string, error) {
+  var netPol networkingv1.NetworkPolicy
+  polBytes, err := json.Marshal(rawPol)
+  if err != nil {
+    return netPol, nil, err
+  }
+  err = json.Unmarshal(polBytes, &netPol)
+  if err != nil {
+    return netPol, nil, err
+  }
+  decodedBytes, err := json.Marshal(netPol)
+  if err != nil {
+    return netPol, nil, err
+  }
+  var decodedPol map[string]interface{}
+  err = json.Unmarshal(decodedBytes, &decodedPol)
+  if err != nil {
+    return netPol, nil, err
+  }
+  unknownFields := make([]string, 0)
+  for _, unknownField := range getUnknownFields(rawPol["spec"], decodedPol["spec"], "spec") {
+    //endPort is only missing from the NetworkPolicy type we use, DanmNetworkPolicy supports it
+    if !strings.HasSuffix(unknownField, ".endPort") {
+      unknownFields = append(unknownFields, unknownField)
+    }
+  }
+  sort.Strings(unknownFields)
+  return netPol, unknownFields, nil
+}
+
+//getUnknownFields returns the paths of the fields present in the raw object, but missing from the decoded one
+func getUnknownFields(rawValue, decodedValue interface{}, path string) []string {
+  unknownFields := make([]string, 0)
+  switch raw := rawValue.(type) {
+  case map[string]interface{}:
+    decoded, _ := decodedValue.(map[string]interface{})
+    for key, value := range raw {
+      decodedChild, ok := decoded[key]
+      if !ok {
+        //Empty values are omitted from the decoded object, but they do not carry any meaning either
+        if !isEmptyValue(value) {
+          unknownFields = append(unknownFields, path + "." + key)
+        }
+        continue
+      }
+      unknownFields = append(unknownFields, getUnknownFields(value, decodedChild, path + "." + key)...)
+    }
+  case []interface{}:
+    decoded, _ := decodedValue.([]interface{})
+    for i, value := range raw {
+      if i < len(decoded) {
+        unknownFields = append(unknownFields, getUnknownFields(value, decoded[i], path + "[" + strconv.Itoa(i) + "]")...)
+      }
+    }
+  }
+  return unknownFields
+}
+
+func isEmptyValue(value interface{}) bool {
+  switch typedValue := value.(type) {
+  case nil:
+    return true
+  case map[string]interface{}:
+    return len(typedValue) == 0
+  case []interface{}:
+    return len(typedValue) == 0
+  case string:
+    return typedValue == ""
+  }
+  return false
+}
+
+//ConvertNetworkPolicy translates a NetworkPolicy into a DanmNetworkPolicy with the same name, in the same namespace
+//The raw object of the NetworkPolicy is optional. When it is provided, the endPort fields are translated from it too
+func ConvertNetworkPolicy(netPol networkingv1.NetworkPolicy, rawPol map[string]interface{}, opts Options) (polv1.DanmNetworkPolicy, []Issue) {
+  issues := make([]Issue, 0)
+  dnp := polv1.DanmNetworkPolicy {
+    TypeMeta: metav1.TypeMeta{APIVersion: polv1.SchemeGroupVersion.String(), Kind: DanmNetworkPolicyKind},
+    ObjectMeta: metav1.ObjectMeta {
+      Name:        netPol.ObjectMeta.Name,
+      Namespace:   netPol.ObjectMeta.Namespace,
+      Labels:      netPol.ObjectMeta.Labels,
+      Annotations: getAnnotations(netPol.ObjectMeta.Annotations),
+    },
+    Spec: polv1.NetPolSpec{PodSelector: *netPol.Spec.PodSelector.DeepCopy()},
+  }
+  for _, policyType := range netPol.Spec.PolicyTypes {
+    dnp.Spec.PolicyTypes = append(dnp.Spec.PolicyTypes, networking.PolicyType(policyType))
+  }
+  for i, ingressRule := range netPol.Spec.Ingress {
+    rulePath := "spec.ingress[" + strconv.Itoa(i) + "]"
+    peers, peerIssues := convertPeers(ingressRule.From, opts, getPolicyKey(netPol), rulePath + ".from")
+    issues = append(issues, peerIssues...)
+    dnp.Spec.Ingress = append(dnp.Spec.Ingress, polv1.NetworkPolicyIngressRule {
+      From:  peers,
+      Ports: convertPorts(ingressRule.Ports, getRawPorts(rawPol, "ingress", i)),
+    })
+  }
+  for i, egressRule := range netPol.Spec.Egress {
+    rulePath := "spec.egress[" + strconv.Itoa(i) + "]"
+    peers, peerIssues := convertPeers(egressRule.To, opts, getPolicyKey(netPol), rulePath + ".to")
+    issues = append(issues, peerIssues...)
+    dnp.Spec.Egress = append(dnp.Spec.Egress, polv1.NetworkPolicyEgressRule {
+      To:    peers,
+      Ports: convertPorts(egressRule.Ports, getRawPorts(rawPol, "egress", i)),
+    })
+  }
+  return dnp, issues
+}
+
+func getAnnotations(annotations map[string]string) map[string]string {
+  if len(annotations) == 0 {
+    return nil
+  }
+  newAnnotations := make(map[string]string)
+  for key, value := range annotations {
+    if key != LastAppliedAnnotation {
+      newAnnotations[key] = value
+    }
+  }
+  if len(newAnnotations) == 0 {
+    return nil
+  }
+  return newAnnotations
+}
+
+//convertPeers translates the peers of a rule, injecting the default network selector into the peers having any selectors
+//Peers without any selectors are invalid in NetworkPolicies, so they are left out
+func convertPeers(netPolPeers []networkingv1.NetworkPolicyPeer, opts Options, polKey, peersPath string) ([]polv1.NetworkPolicyPeer, []Issue) {
+  issues := make([]Issue, 0)
+  if netPolPeers == nil {
+    return nil, issues
+  }
+  peers := make([]polv1.NetworkPolicyPeer, 0)
+  for i, netPolPeer := range netPolPeers {
+    if netPolPeer.PodSelector == nil && netPolPeer.NamespaceSelector == nil && netPolPeer.IPBlock == nil {
+      issues = append(issues, Issue{Policy: polKey, Field: peersPath + "[" + strconv.Itoa(i) + "]", Message: "peer without any selectors is invalid, it is left out"})
+      continue
+    }
+    peer := polv1.NetworkPolicyPeer{NamespaceSelector: netPolPeer.NamespaceSelector.DeepCopy()}
+    if netPolPeer.PodSelector != nil {
+      peer.PodSelector = *netPolPeer.PodSelector.DeepCopy()
+    }
+    if netPolPeer.IPBlock != nil {
+      peer.IPBlock = &polv1.IPBlock{CIDR: netPolPeer.IPBlock.CIDR, Except: netPolPeer.IPBlock.Except}
+    }
+    if len(opts.NetworkSelector) > 0 {
+      peer.NetworkSelector = append([]polv1.NetworkSelector{}, opts.NetworkSelector...)
+    }
+    //An empty podSelector selects all the Pods of the namespace in a NetworkPolicy, but a peer without any selectors whitelists all addresses in a DanmNetworkPolicy
+    if len(peer.PodSelector.MatchLabels) == 0 && len(peer.PodSelector.MatchExpressions) == 0 &&
+       peer.NamespaceSelector == nil && len(peer.NetworkSelector) == 0 && peer.IPBlock == nil {
+      if opts.SelectNamespacePods {
+        peer.PodSelector = metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: NamespacePodsSelectorKey, Operator: metav1.LabelSelectorOpDoesNotExist}}}
+      } else {
+        issues = append(issues, Issue{Policy: polKey, Field: peersPath + "[" + strconv.Itoa(i) + "].podSelector",
+          Message: "empty podSelector whitelists all addresses in DanmNetworkPolicy instead of all the Pods of the namespace, restrict it with a networkSelector"})
+      }
+    }
+    peers = append(peers, peer)
+  }
+  return peers, issues
+}
+
+func convertPorts(netPolPorts []networkingv1.NetworkPolicyPort, rawPorts []interface{}) []polv1.NetworkPolicyPort {
+  if netPolPorts == nil {
+    return nil
+  }
+  ports := make([]polv1.NetworkPolicyPort, 0)
+  for i, netPolPort := range netPolPorts {
+    port := polv1.NetworkPolicyPort{Protocol: netPolPort.Protocol}
+    if netPolPort.Port != nil {
+      port.Port = &intstr.IntOrString{Type: netPolPort.Port.Type, IntVal: netPolPort.Port.IntVal, StrVal: netPolPort.Port.StrVal}
+    }
+    if i < len(rawPorts) {
+      port.EndPort = getRawEndPort(rawPorts[i])
+    }
+    ports = append(ports, port)
+  }
+  return ports
+}
+
+//getRawPorts returns the ports of the ingress, or egress rule with the given index from the raw NetworkPolicy
+func getRawPorts(rawPol map[string]interface{}, direction string, ruleIndex int) []interface{} {
+  spec, _ := rawPol["spec"].(map[string]interface{})
+  rules, _ := spec[direction].([]interface{})
+  if ruleIndex >= len(rules) {
+    return nil
+  }
+  rule, _ := rules[ruleIndex].(map[string]interface{})
+  ports, _ := rule["ports"].([]interface{})
+  return ports
+}
+
+//getRawEndPort accepts both the float64 numbers of a decoded manifest, and the int64 numbers of an unstructured API object
+func getRawEndPort(rawPort interface{}) *int32 {
+  port, _ := rawPort.(map[string]interface{})
+  var endPortInt int32
+  switch endPort := port["endPort"].(type) {
+  case float64:
+    endPortInt = int32(endPort)
+  case int64:
+    endPortInt = int32(endPort)
+  default:
+    return nil
+  }
+  return &endPortInt
+}
+
+//EncodeDanmNetworkPolicies writes the DanmNetworkPolicies as a multi-document YAML
+//The status, and the fields filled by the API server are left out, so the output can be directly applied
+func EncodeDanmNetworkPolicies(writer io.Writer, dnps []polv1.DanmNetworkPolicy) error {
+  var buffer bytes.Buffer
+  for _, dnp := range dnps {
+    dnpBytes, err := encodeManifest(dnp)
+    if err != nil {
+      return errors.New("DanmNetworkPolicy:" + dnp.ObjectMeta.Namespace + "/" + dnp.ObjectMeta.Name + " could not be encoded because:" + err.Error())
+    }
+    buffer.WriteString("---\n")
+    buffer.Write(dnpBytes)
+  }
+  _, err := writer.Write(buffer.Bytes())
+  return err
+}
+
+func encodeManifest(dnp polv1.DanmNetworkPolicy) ([]byte, error) {
+  dnpBytes, err := json.Marshal(dnp)
+  if err != nil {
+    return nil, err
+  }
+  var manifest map[string]interface{}
+  err = json.Unmarshal(dnpBytes, &manifest)
+  if err != nil {
+    return nil, err
+  }
+  delete(manifest, "status")
+  if metadata, ok := manifest["metadata"].(map[string]interface{}); ok {
+    delete(metadata, "creationTimestamp")
+  }
+  return yaml.Marshal(manifest)
+}
\ No newline at end of file
@@ -0,0 +1,186 @@
+package convert
+
+import (
+  "bytes"
+  "strings"
+  "testing"
+  polv1 "github.com/nokia/danm-utils/crd/api/netpol/v1"
+)
+
+const (
+  dbPolicy = `
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: db
+  namespace: default
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: "{}"
+spec:
+  podSelector:
+    matchLabels: {app: db}
+  ingress:
+  - from:
+    - podSelector:
+        matchLabels: {app: web}
+    - ipBlock: {cidr: 10.0.0.0/8, except: [10.1.0.0/16]}
+    ports:
+    - {protocol: TCP, port: 5432, endPort: 5440}
+  egress: []
+`
+  dbDnp = `---
+apiVersion: danm.k8s.io/v1
+kind: DanmNetworkPolicy
+metadata:
+  name: db
+  namespace: default
+spec:
+  ingress:
+  - from:
+    - podSelector:
+        matchLabels:
+          app: web
+    - ipBlock:
+        cidr: 10.0.0.0/8
+        except:
+        - 10.1.0.0/16
+      podSelector: {}
+    ports:
+    - endPort: 5440
+      port: 5432
+      protocol: TCP
+  podSelector:
+    matchLabels:
+      app: db
+`
+  dbDnpWithNetwork = `---
+apiVersion: danm.k8s.io/v1
+kind: DanmNetworkPolicy
+metadata:
+  name: db
+  namespace: default
+spec:
+  ingress:
+  - from:
+    - networkSelector:
+      - name: oam
+        type: ClusterNetwork
+      podSelector:
+        matchLabels:
+          app: web
+    - ipBlock:
+        cidr: 10.0.0.0/8
+        except:
+        - 10.1.0.0/16
+      networkSelector:
+      - name: oam
+        type: ClusterNetwork
+      podSelector: {}
+    ports:
+    - endPort: 5440
+      port: 5432
+      protocol: TCP
+  podSelector:
+    matchLabels:
+      app: db
+`
+  unsupportedPolicies = `
+{"apiVersion": "v1", "kind": "List", "items": [
+  {"apiVersion": "networking.k8s.io/v1", "kind": "NetworkPolicy", "metadata": {"name": "web", "namespace": "prod"},
+   "spec": {"podSelector": {}, "policyTypes": ["Egress"], "egress": [{"to": [{"podSelector": {}}, {}], "ports": [{"port": "http", "portRange": "80-90"}]}]}},
+  {"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "config", "namespace": "prod"}}
+]}
+`
+  webDnpWithNamespacePods = `---
+apiVersion: danm.k8s.io/v1
+kind: DanmNetworkPolicy
+metadata:
+  name: web
+  namespace: prod
+spec:
+  egress:
+  - ports:
+    - port: http
+    to:
+    - podSelector:
+        matchExpressions:
+        - key: danm.k8s.io/no-such-label
+          operator: DoesNotExist
+  podSelector: {}
+  policyTypes:
+  - Egress
+`
+  webDnp = `---
+apiVersion: danm.k8s.io/v1
+kind: DanmNetworkPolicy
+metadata:
+  name: web
+  namespace: prod
+spec:
+  egress:
+  - ports:
+    - port: http
+    to:
+    - podSelector: {}
+  podSelector: {}
+  policyTypes:
+  - Egress
+`
+)
+
+var convertTcs = []struct {
+  tcName string
+  input string
+  opts Options
+  expectedOutput string
+  expectedIssues []string
+}{
+  {"multiDocument", dbPolicy + "---\n" + dbPolicy, Options{}, dbDnp + dbDnp, []string{}},
+  {"defaultNetwork", dbPolicy, Options{NetworkSelector: []polv1.NetworkSelector{{Name: "oam", Type: "ClusterNetwork"}}}, dbDnpWithNetwork, []string{}},
+  {"unsupportedConstructs", unsupportedPolicies, Options{}, webDnp, []string{"prod/web: spec.egress[0].ports[0].portRange", "prod/config: kind", "prod/web: spec.egress[0].to[0].podSelector", "prod/web: spec.egress[0].to[1]"}},
+  {"emptyPodSelectorWithNetwork", unsupportedPolicies, Options{NetworkSelector: []polv1.NetworkSelector{{Name: "internal"}}}, "", []string{"prod/web: spec.egress[0].ports[0].portRange", "prod/config: kind", "prod/web: spec.egress[0].to[1]"}},
+  {"selectNamespacePods", unsupportedPolicies, Options{SelectNamespacePods: true}, webDnpWithNamespacePods, []string{"prod/web: spec.egress[0].ports[0].portRange", "prod/config: kind", "prod/web: spec.egress[0].to[1]"}},
+}
+
+func TestConvert(t *testing.T) {
+  for _, tc := range convertTcs {
+    t.Run(tc.tcName, func(t *testing.T) {
+      netPols, rawPols, issues, err := DecodeNetworkPolicies(strings.NewReader(tc.input))
+      if err != nil {
+        t.Fatalf("Decoding the NetworkPolicies failed with error:%s", err.Error())
+      }
+      dnps := make([]polv1.DanmNetworkPolicy, 0)
+      for i, netPol := range netPols {
+        dnp, convIssues := ConvertNetworkPolicy(netPol, rawPols[i], tc.opts)
+        issues = append(issues, convIssues...)
+        dnps = append(dnps, dnp)
+      }
+      if len(issues) != len(tc.expectedIssues) {
+        t.Fatalf("Expected issues:%v, but we got:%v", tc.expectedIssues, issues)
+      }
+      for i, issue := range issues {
+        if !strings.HasPrefix(issue.String(), tc.expectedIssues[i] + ":") {
+          t.Errorf("Expected issue:%s, but we got:%s", tc.expectedIssues[i], issue.String())
+        }
+      }
+      if tc.expectedOutput == "" {
+        return
+      }
+      var output bytes.Buffer
+      err = EncodeDanmNetworkPolicies(&output, dnps)
+      if err != nil {
+        t.Fatalf("Encoding the DanmNetworkPolicies failed with error:%s", err.Error())
+      }
+      if output.String() != tc.expectedOutput {
+        t.Errorf("Expected DanmNetworkPolicies:\n%s\nbut we got:\n%s", tc.expectedOutput, output.String())
+      }
+    })
+  }
+}
+
+func TestDecodeInvalidInput(t *testing.T) {
+  _, _, _, err := DecodeNetworkPolicies(strings.NewReader("{\"kind\": "))
+  if err == nil {
+    t.Errorf("Expected decoding error for a truncated input")
+  }
+}
\ No newline at end of file
@@ -1,50 +1,144 @@
 package depset
 
 import (
-  "context"
   "log"
+  "sort"
   danmv1 "github.com/nokia/danm/crd/apis/danm/v1"
-  danmclientset "github.com/nokia/danm/crd/client/clientset/versioned"
   "github.com/nokia/danm-utils/types/poltypes"
   corev1 "k8s.io/api/core/v1"
-  metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+  "k8s.io/apimachinery/pkg/labels"
+  corelisters "k8s.io/client-go/listers/core/v1"
+  "k8s.io/client-go/tools/cache"
 )
 
-func NewDanmEpSet(danmClient danmclientset.Interface, pod *corev1.Pod) *poltypes.DanmEpSet {
-  var depSet poltypes.DanmEpSet
-  deps, err := danmClient.DanmV1().DanmEps(pod.ObjectMeta.Namespace).List(context.TODO(), metav1.ListOptions{})
+const (
+  LabelIndex   = "label"
+  NetworkIndex = "network"
+  PodIndex     = "pod"
+)
+
+//Indexers sort the DanmEps of an informer into the same buckets as the ones used by DanmEpSet
+//The informer keeps the buckets up-to-date on every DanmEp event, so they never have to be re-built when the rules of a Pod are calculated
+var Indexers = cache.Indexers {
+  LabelIndex:           labelIndexFunc,
+  NetworkIndex:         networkIndexFunc,
+  PodIndex:             podIndexFunc,
+  cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+}
+
+func labelIndexFunc(obj interface{}) ([]string, error) {
+  dep, ok := obj.(*danmv1.DanmEp)
+  if !ok {
+    return nil, nil
+  }
+  buckets := make([]string, 0)
+  for key, value := range dep.ObjectMeta.Labels {
+    buckets = append(buckets, key+value+poltypes.CustomBucketPostfix)
+  }
+  return buckets, nil
+}
+
+func networkIndexFunc(obj interface{}) ([]string, error) {
+  dep, ok := obj.(*danmv1.DanmEp)
+  if !ok {
+    return nil, nil
+  }
+  return []string{getNetworkBucketName(*dep)}, nil
+}
+
+func podIndexFunc(obj interface{}) ([]string, error) {
+  dep, ok := obj.(*danmv1.DanmEp)
+  if !ok {
+    return nil, nil
+  }
+  return []string{string(dep.Spec.PodUID)}, nil
+}
+
+func getNetworkBucketName(dep danmv1.DanmEp) string {
+  networkBucketName := dep.Spec.NetworkName + dep.Spec.ApiType
+  if dep.Spec.ApiType == "" {
+    networkBucketName += poltypes.DanmNetKind
+  }
+  return networkBucketName
+}
+
+//indexBuckets serves the buckets of a DanmEpSet from one of the indices of an informer
+type indexBuckets struct {
+  indexer   cache.Indexer
+  indexName string
+}
+
+func (buckets indexBuckets) Get(bucket string) []danmv1.DanmEp {
+  objs, err := buckets.indexer.ByIndex(buckets.indexName, bucket)
   if err != nil {
-    log.Println("ERROR: can't list DANM DanmEps API because:" + err.Error())
-    return &depSet
+    log.Println("ERROR: can't get DanmEps from bucket:" + bucket + " of index:" + buckets.indexName + " because:" + err.Error())
+    return make([]danmv1.DanmEp, 0)
   }
-  depSet.DanmEpsByLabel, depSet.DanmEpsByNetwork, depSet.PodEps = sortDeps(deps.Items, pod)
-  return &depSet
+  return toDanmEps(objs)
+}
+
+func (buckets indexBuckets) List() []danmv1.DanmEp {
+  return toDanmEps(buckets.indexer.List())
 }
 
-func sortDeps(deps []danmv1.DanmEp, pod *corev1.Pod) (poltypes.DanmEpBuckets,poltypes.DanmEpBuckets,[]danmv1.DanmEp)  {
-  depPodLabelBuckets := make(poltypes.DanmEpBuckets, 0)
-  depNetworkBuckets := make(poltypes.DanmEpBuckets, 0)
-  depUidCache := make(map[string]poltypes.UidCache, 0)
-  podEps := make([]danmv1.DanmEp, 0)
-  for _, dep := range deps {
-    if dep.Spec.PodUID == pod.ObjectMeta.UID {
-      podEps = append(podEps, dep)
+//toDanmEps returns the DanmEps ordered by their namespace, and name
+//Indices return their objects in random order, but the rules calculated from them must not change between two calculations
+func toDanmEps(objs []interface{}) []danmv1.DanmEp {
+  deps := make([]danmv1.DanmEp, 0)
+  for _, obj := range objs {
+    if dep, ok := obj.(*danmv1.DanmEp); ok {
+      deps = append(deps, *dep)
     }
-    networkBucketName := dep.Spec.NetworkName + dep.Spec.ApiType
-    if dep.Spec.ApiType == "" {
-      networkBucketName += poltypes.DanmNetKind
+  }
+  sort.Slice(deps, func(i, j int) bool {
+    if deps[i].ObjectMeta.Namespace != deps[j].ObjectMeta.Namespace {
+      return deps[i].ObjectMeta.Namespace < deps[j].ObjectMeta.Namespace
     }
-    depNetworkBuckets[networkBucketName] = append(depNetworkBuckets[networkBucketName], dep)
-    for key, value := range dep.ObjectMeta.Labels {
-      if _, ok := depUidCache[key+value+poltypes.CustomBucketPostfix][dep.ObjectMeta.UID]; !ok {
-        if depUidCache[key+value+poltypes.CustomBucketPostfix] == nil {
-          cache := make(poltypes.UidCache, 0)
-          depUidCache[key+value+poltypes.CustomBucketPostfix] = cache
-        }
-        depUidCache[key+value+poltypes.CustomBucketPostfix][dep.ObjectMeta.UID] = true
-        depPodLabelBuckets[key+value+poltypes.CustomBucketPostfix] = append(depPodLabelBuckets[key+value+poltypes.CustomBucketPostfix], dep)
-      }
+    return deps[i].ObjectMeta.Name < deps[j].ObjectMeta.Name
+  })
+  return deps
+}
+
+//NewDanmEpSet creates the DanmEpSet of the Pod from the cache of a DanmEp informer having the Indexers of this package
+//The buckets contain the DanmEps of all namespaces, the policies of the Pod decide which namespaces are selected
+func NewDanmEpSet(depIndexer cache.Indexer, nsLister corelisters.NamespaceLister, pod *corev1.Pod) *poltypes.DanmEpSet {
+  depSet := poltypes.DanmEpSet {
+    DanmEpsByLabel:     indexBuckets{indexer: depIndexer, indexName: LabelIndex},
+    DanmEpsByNetwork:   indexBuckets{indexer: depIndexer, indexName: NetworkIndex},
+    DanmEpsByNamespace: indexBuckets{indexer: depIndexer, indexName: cache.NamespaceIndex},
+    PodEps:             indexBuckets{indexer: depIndexer, indexName: PodIndex}.Get(string(pod.ObjectMeta.UID)),
+    NamespaceLabels:    make(map[string]map[string]string, 0),
+  }
+  namespaces, err := nsLister.List(labels.Everything())
+  if err != nil {
+    log.Println("ERROR: can't list Namespaces because:" + err.Error() + ", namespace selectors won't select any peers!")
+    return &depSet
+  }
+  for _, namespace := range namespaces {
+    depSet.NamespaceLabels[namespace.ObjectMeta.Name] = namespace.ObjectMeta.Labels
+  }
+  return &depSet
+}
+
+//NewPeerDanmEpSet creates a DanmEpSet purely from the provided DanmEps, without any of them belonging to a selected Pod
+//It is used to evaluate whether the provided DanmEps are selected as peers by a set of policies
+func NewPeerDanmEpSet(deps []danmv1.DanmEp, namespaceLabels map[string]map[string]string) *poltypes.DanmEpSet {
+  depsByLabel     := make(poltypes.DanmEpMap, 0)
+  depsByNetwork   := make(poltypes.DanmEpMap, 0)
+  depsByNamespace := make(poltypes.DanmEpMap, 0)
+  for i := range deps {
+    labelBuckets, _ := labelIndexFunc(&deps[i])
+    for _, bucket := range labelBuckets {
+      depsByLabel[bucket] = append(depsByLabel[bucket], deps[i])
     }
+    networkBucketName := getNetworkBucketName(deps[i])
+    depsByNetwork[networkBucketName] = append(depsByNetwork[networkBucketName], deps[i])
+    depsByNamespace[deps[i].ObjectMeta.Namespace] = append(depsByNamespace[deps[i].ObjectMeta.Namespace], deps[i])
+  }
+  return &poltypes.DanmEpSet {
+    DanmEpsByLabel:     depsByLabel,
+    DanmEpsByNetwork:   depsByNetwork,
+    DanmEpsByNamespace: depsByNamespace,
+    NamespaceLabels:    namespaceLabels,
   }
-  return depPodLabelBuckets, depNetworkBuckets, podEps
 }
\ No newline at end of file
@@ -0,0 +1,62 @@
+package depset
+
+import (
+  "testing"
+  danmv1 "github.com/nokia/danm/crd/apis/danm/v1"
+  "github.com/nokia/danm-utils/types/poltypes"
+  corev1 "k8s.io/api/core/v1"
+  metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+  "k8s.io/apimachinery/pkg/types"
+  corelisters "k8s.io/client-go/listers/core/v1"
+  "k8s.io/client-go/tools/cache"
+)
+
+const (
+  testNamespace = "default"
+)
+
+//The buckets of the DanmEpSet are always served from the current state of the informer cache
+func TestDanmEpSetFollowsIndexer(t *testing.T) {
+  depIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, Indexers)
+  depIndexer.Add(newTestDep("isolated", "isolated", "internal", map[string]string{"app": "web"}))
+  depIndexer.Add(newTestDep("db", "db", "internal", map[string]string{"app": "db"}))
+  pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "isolated", Namespace: testNamespace, UID: types.UID("isolated")}}
+  depSet := NewDanmEpSet(depIndexer, newTestNamespaceLister(), pod)
+  if len(depSet.PodEps) != 1 || depSet.PodEps[0].ObjectMeta.Name != "isolated" {
+    t.Errorf("Only DanmEp isolated should belong to the Pod, but we got:%v", depSet.PodEps)
+  }
+  if deps := depSet.DanmEpsByNetwork.Get("internal" + poltypes.DanmNetKind); len(deps) != 2 {
+    t.Errorf("Both DanmEps should be in the bucket of network internal, but we got:%v", deps)
+  }
+  depIndexer.Update(newTestDep("db", "db", "internal", map[string]string{"app": "web"}))
+  if deps := depSet.DanmEpsByLabel.Get("app" + "db" + poltypes.CustomBucketPostfix); len(deps) != 0 {
+    t.Errorf("Relabeled DanmEp should have been removed from its old label bucket, but we got:%v", deps)
+  }
+  if deps := depSet.DanmEpsByLabel.Get("app" + "web" + poltypes.CustomBucketPostfix); len(deps) != 2 || deps[0].ObjectMeta.Name != "db" {
+    t.Errorf("Relabeled DanmEp should have been added to its new label bucket in name order, but we got:%v", deps)
+  }
+}
+
+func TestNewPeerDanmEpSet(t *testing.T) {
+  deps := []danmv1.DanmEp{*newTestDep("db", "db", "internal", map[string]string{"app": "db", "tier": "prod"})}
+  depSet := NewPeerDanmEpSet(deps, nil)
+  if len(depSet.PodEps) != 0 {
+    t.Errorf("Peer DanmEpSet should not contain any DanmEps of a Pod, but we got:%v", depSet.PodEps)
+  }
+  if peerDeps := depSet.DanmEpsByLabel.List(); len(peerDeps) != 1 {
+    t.Errorf("DanmEp having multiple labels should be listed once, but we got:%v", peerDeps)
+  }
+}
+
+func newTestDep(name, podName, network string, labels map[string]string) *danmv1.DanmEp {
+  return &danmv1.DanmEp {
+    ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, UID: types.UID(name), Labels: labels},
+    Spec: danmv1.DanmEpSpec{NetworkName: network, Pod: podName, PodUID: types.UID(podName)},
+  }
+}
+
+func newTestNamespaceLister() corelisters.NamespaceLister {
+  namespaceIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+  namespaceIndexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: testNamespace}})
+  return corelisters.NewNamespaceLister(namespaceIndexer)
+}
\ No newline at end of file
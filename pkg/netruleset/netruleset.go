@@ -2,71 +2,481 @@ package netruleset
 
 import (
   "log"
+  "net"
+  "sort"
+  "strconv"
   "strings"
   danmv1 "github.com/nokia/danm/crd/apis/danm/v1"
   "github.com/nokia/danm/pkg/ipam"
   polv1 "github.com/nokia/danm-utils/crd/api/netpol/v1"
+  "github.com/nokia/danm-utils/pkg/depset"
   "github.com/nokia/danm-utils/types/poltypes"
+  corev1 "k8s.io/api/core/v1"
+  "k8s.io/apimachinery/pkg/types"
   metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+  "k8s.io/apimachinery/pkg/labels"
+  "k8s.io/apimachinery/pkg/util/intstr"
   "k8s.io/kubernetes/pkg/apis/networking"
 )
 
-type RuleParser func(address string, ports []networking.NetworkPolicyPort) []poltypes.NetRule
+//RuleParser creates the rules whitelisting the address on the provided ports
+//Address is either a host address, or a CIDR. When iface is set the rules are restricted to the given interface of the isolated Pod
+type RuleParser func(address, iface string, ports []poltypes.NetPort) []poltypes.NetRule
 
-func NewNetRuleSet(polSet []polv1.DanmNetworkPolicy, depSet *poltypes.DanmEpSet) *poltypes.NetRuleSet {
+//PodGetter returns the Pod with the given name from the given namespace
+//The UID identifies the Pod the DanmEp was created for, so a recreated Pod with the same name is never mistaken for it
+type PodGetter func(namespace, name string, uid types.UID) (*corev1.Pod, error)
+
+//portPodGetter returns the Pod whose container ports the named ports of a rule refer to
+//The DanmEp is the selected peer, or nil in case of ipBlock peers
+type portPodGetter func(dep *danmv1.DanmEp) *corev1.Pod
+
+//NewNetRuleSet calculates the rules of the isolated Pod from the policies selecting it
+//Rules are put into the chains in the order of the priority of their policies, so Deny rules only override the Allow rules of the same, or later priorities
+//Named ports of ingress rules are resolved against the isolated Pod, while named ports of egress rules are resolved against the peer Pods fetched via the PodGetter
+func NewNetRuleSet(polSet []polv1.DanmNetworkPolicy, depSet *poltypes.DanmEpSet, pod *corev1.Pod, podGetter PodGetter) *poltypes.NetRuleSet {
+  getIngressPortPod := func(dep *danmv1.DanmEp) *corev1.Pod {
+    return pod
+  }
+  //The same peer can be selected by multiple rules, but it is only fetched once
+  peerPods := make(map[string]*corev1.Pod)
+  getEgressPortPod := func(dep *danmv1.DanmEp) *corev1.Pod {
+    if dep == nil {
+      return nil
+    }
+    podKey := dep.ObjectMeta.Namespace + "/" + dep.Spec.Pod
+    if peerPod, ok := peerPods[podKey]; ok {
+      return peerPod
+    }
+    peerPod, err := podGetter(dep.ObjectMeta.Namespace, dep.Spec.Pod, dep.Spec.PodUID)
+    if err != nil {
+      log.Println("WARNING: named ports of Pod:" + dep.Spec.Pod + " in namespace:" + dep.ObjectMeta.Namespace + " can't be resolved because:" + err.Error())
+      peerPod = nil
+    }
+    peerPods[podKey] = peerPod
+    return peerPod
+  }
   ruleSet := poltypes.NetRuleSet{Netns: depSet.PodEps[0].Spec.Netns}
   ruleSet.IngressV4Chain.Name = poltypes.IngressV4ChainName
   ruleSet.IngressV6Chain.Name = poltypes.IngressV6ChainName
   ruleSet.EgressV4Chain.Name = poltypes.EgressV4ChainName
   ruleSet.EgressV6Chain.Name = poltypes.EgressV6ChainName
-  for _, policy := range polSet {
-    //TODO: is it really necessary for Ingress / Egress to be list?
-    // Format is kept to be consistent with upstream, but there is really no use-case for having multiple from/to sections in a network policy
-    if len(policy.Spec.Ingress) > 0 {
-       ingressV4Rules, ingressV6Rules := parsePolicyRules(depSet, policy.Spec.Ingress[0].From, policy.Spec.Ingress[0].Ports, newIngressNetRules)
-       ruleSet.IngressV4Chain.Rules = append(ruleSet.IngressV4Chain.Rules, ingressV4Rules...)
-       ruleSet.IngressV6Chain.Rules = append(ruleSet.IngressV6Chain.Rules, ingressV6Rules...)
+  ingressIfaces, egressIfaces := make(ifaceSet, 0), make(ifaceSet, 0)
+  for _, policy := range sortPoliciesByPriority(polSet) {
+    //Policies only protecting networks the Pod is not connected to do not isolate the Pod at all
+    targetIfaces := selectPodIfaces(depSet, policy.Spec.TargetNetworkSelector)
+    if len(targetIfaces) == 0 {
+      continue
+    }
+    isIngressPolicy, isEgressPolicy := getPolicyTypes(policy)
+    //A Pod is isolated in a direction when any policy of that type selects it, even if the policy does not whitelist anything
+    ruleSet.IsIngressIsolated = ruleSet.IsIngressIsolated || isIngressPolicy
+    ruleSet.IsEgressIsolated = ruleSet.IsEgressIsolated || isEgressPolicy
+    if isIngressPolicy {
+      ingressIfaces.add(targetIfaces)
     }
-    if len(policy.Spec.Egress) > 0 {
-      egressV4Rules, egressV6Rules  := parsePolicyRules(depSet, policy.Spec.Egress[0].To, policy.Spec.Egress[0].Ports, newEgressNetRules)
-      ruleSet.EgressV4Chain.Rules = append(ruleSet.EgressV4Chain.Rules, egressV4Rules...)
-      ruleSet.EgressV6Chain.Rules = append(ruleSet.EgressV6Chain.Rules, egressV6Rules...)
+    if isEgressPolicy {
+      egressIfaces.add(targetIfaces)
+    }
+    //Allow rules are additive: every rule whitelists its own peers on its own ports
+    if isIngressPolicy {
+      for _, ingressRule := range policy.Spec.Ingress {
+        ingressV4Rules, ingressV6Rules := parsePolicyRules(depSet, policy.ObjectMeta.Namespace, ingressRule.From, ingressRule.Ports, ingressRule.Action, newIngressNetRules, getIngressPortPod)
+        ruleSet.IngressV4Chain.Rules = append(ruleSet.IngressV4Chain.Rules, restrictRules(ingressV4Rules, targetIfaces, true)...)
+        ruleSet.IngressV6Chain.Rules = append(ruleSet.IngressV6Chain.Rules, restrictRules(ingressV6Rules, targetIfaces, true)...)
+        ingressV4Rules, ingressV6Rules = parseIpBlockRules(depSet, ingressRule.From, ingressRule.Ports, ingressRule.Action, newIngressNetRules, getIngressPortPod, &ruleSet.IngressV4Chain, &ruleSet.IngressV6Chain)
+        ruleSet.IngressV4Chain.Rules = append(ruleSet.IngressV4Chain.Rules, restrictRules(ingressV4Rules, targetIfaces, true)...)
+        ruleSet.IngressV6Chain.Rules = append(ruleSet.IngressV6Chain.Rules, restrictRules(ingressV6Rules, targetIfaces, true)...)
+      }
+    }
+    if isEgressPolicy {
+      for _, egressRule := range policy.Spec.Egress {
+        egressV4Rules, egressV6Rules := parsePolicyRules(depSet, policy.ObjectMeta.Namespace, egressRule.To, egressRule.Ports, egressRule.Action, newEgressNetRules, getEgressPortPod)
+        ruleSet.EgressV4Chain.Rules = append(ruleSet.EgressV4Chain.Rules, restrictRules(egressV4Rules, targetIfaces, false)...)
+        ruleSet.EgressV6Chain.Rules = append(ruleSet.EgressV6Chain.Rules, restrictRules(egressV6Rules, targetIfaces, false)...)
+        egressV4Rules, egressV6Rules = parseIpBlockRules(depSet, egressRule.To, egressRule.Ports, egressRule.Action, newEgressNetRules, getEgressPortPod, &ruleSet.EgressV4Chain, &ruleSet.EgressV6Chain)
+        ruleSet.EgressV4Chain.Rules = append(ruleSet.EgressV4Chain.Rules, restrictRules(egressV4Rules, targetIfaces, false)...)
+        ruleSet.EgressV6Chain.Rules = append(ruleSet.EgressV6Chain.Rules, restrictRules(egressV6Rules, targetIfaces, false)...)
+      }
     }
   }
+  ruleSet.IngressIfaces = ingressIfaces.list()
+  ruleSet.EgressIfaces = egressIfaces.list()
   return &ruleSet
 }
 
-func parsePolicyRules(depSet *poltypes.DanmEpSet, peers []polv1.NetworkPolicyPeer, ports []networking.NetworkPolicyPort, parserFunc RuleParser) ([]poltypes.NetRule,[]poltypes.NetRule) {
+//sortPoliciesByPriority returns the policies ordered by their priority, keeping the original order of policies with the same priority
+func sortPoliciesByPriority(polSet []polv1.DanmNetworkPolicy) []polv1.DanmNetworkPolicy {
+  sortedPols := make([]polv1.DanmNetworkPolicy, len(polSet))
+  copy(sortedPols, polSet)
+  sort.SliceStable(sortedPols, func(i, j int) bool {
+    return sortedPols[i].Spec.Priority < sortedPols[j].Spec.Priority
+  })
+  return sortedPols
+}
+
+//applyAction turns the whitelisting rules into REJECT rules for Deny rules of the policies
+//Deny rules only reject new connections, so the replies of the connections allowed in the other direction still get through
+func applyAction(rules []poltypes.NetRule, action polv1.RuleAction) []poltypes.NetRule {
+  if action != polv1.RuleActionDeny {
+    return rules
+  }
+  for i := range rules {
+    rules[i].State = poltypes.StateNew
+    rules[i].Operation = poltypes.IptablesReject
+  }
+  return rules
+}
+
+//ifaceSet collects the isolated interfaces of a Pod. The empty name means all interfaces are isolated
+type ifaceSet map[string]bool
+
+func (ifaces ifaceSet) add(ifaceNames []string) {
+  for _, iface := range ifaceNames {
+    ifaces[iface] = true
+  }
+}
+
+func (ifaces ifaceSet) list() []string {
+  if _, ok := ifaces[""]; ok {
+    return nil
+  }
+  ifaceNames := make([]string, 0)
+  for iface := range ifaces {
+    ifaceNames = append(ifaceNames, iface)
+  }
+  sort.Strings(ifaceNames)
+  return ifaceNames
+}
+
+//restrictRules restricts the whitelisting rules of a policy to the interfaces it protects
+//Rules already restricted to an interface -e.g. by the network selector of an ipBlock- are dropped when the policy does not protect that interface
+func restrictRules(rules []poltypes.NetRule, targetIfaces []string, isIngress bool) []poltypes.NetRule {
+  restrictedRules := make([]poltypes.NetRule, 0)
+  for _, targetIface := range targetIfaces {
+    for _, rule := range rules {
+      ruleIface := rule.DestIface
+      if isIngress {
+        ruleIface = rule.SourceIface
+      }
+      if targetIface == "" || ruleIface == targetIface {
+        restrictedRules = append(restrictedRules, rule)
+      } else if ruleIface == "" {
+        restrictedRules = append(restrictedRules, poltypes.RestrictToIfaces(rule, []string{targetIface}, isIngress)...)
+      }
+    }
+  }
+  return restrictedRules
+}
+
+//getPolicyTypes tells which directions the policy isolates
+//Just like in upstream, a policy without policyTypes always isolates ingress, and only isolates egress if it has egress rules
+func getPolicyTypes(policy polv1.DanmNetworkPolicy) (bool,bool) {
+  if len(policy.Spec.PolicyTypes) == 0 {
+    return true, len(policy.Spec.Egress) > 0
+  }
+  isIngressPolicy, isEgressPolicy := false, false
+  for _, policyType := range policy.Spec.PolicyTypes {
+    switch policyType {
+    case networking.PolicyTypeIngress:
+      isIngressPolicy = true
+    case networking.PolicyTypeEgress:
+      isEgressPolicy = true
+    }
+  }
+  return isIngressPolicy, isEgressPolicy
+}
+
+//parsePolicyRules creates the rules whitelisting, or in case of Deny rules rejecting all the DanmEps selected by the peers of a rule
+//Missing, or empty peer list, and a peer without any selectors match all addresses on the defined ports
+func parsePolicyRules(depSet *poltypes.DanmEpSet, namespace string, peers []polv1.NetworkPolicyPeer, ports []polv1.NetworkPolicyPort, action polv1.RuleAction, parserFunc RuleParser, getPortPod portPodGetter) ([]poltypes.NetRule,[]poltypes.NetRule) {
+  v4Rules := make([]poltypes.NetRule, 0)
+  v6Rules := make([]poltypes.NetRule, 0)
+  if isAllowAll(peers) {
+    netPorts, ok := resolvePorts(ports, nil, getPortPod)
+    if ok {
+      v4Rules = append(v4Rules, parserFunc("", "", netPorts)...)
+      v6Rules = append(v6Rules, parserFunc("", "", netPorts)...)
+    }
+    return applyAction(v4Rules, action), applyAction(v6Rules, action)
+  }
+  for _, dep := range selectPeerDeps(depSet, namespace, peers) {
+    netPorts, ok := resolvePorts(ports, &dep, getPortPod)
+    if !ok {
+      continue
+    }
+    if dep.Spec.Iface.Address != "" && dep.Spec.Iface.Address != ipam.NoneAllocType {
+      v4Rules = append(v4Rules, parserFunc(strings.Split(dep.Spec.Iface.Address, "/")[0], "", netPorts)...)
+    }
+    if dep.Spec.Iface.AddressIPv6 != "" && dep.Spec.Iface.AddressIPv6 != ipam.NoneAllocType {
+      v6Rules = append(v6Rules, parserFunc(strings.Split(dep.Spec.Iface.AddressIPv6, "/")[0], "", netPorts)...)
+    }
+  }
+  return applyAction(v4Rules, action), applyAction(v6Rules, action)
+}
+
+func isAllowAll(peers []polv1.NetworkPolicyPeer) bool {
+  if len(peers) == 0 {
+    return true
+  }
+  for _, peer := range peers {
+    if len(peer.PodSelector.MatchLabels) == 0 && len(peer.PodSelector.MatchExpressions) == 0 &&
+       peer.NamespaceSelector == nil && len(peer.NetworkSelector) == 0 && peer.IPBlock == nil {
+      return true
+    }
+  }
+  return false
+}
+
+//parseIpBlockRules creates the rules whitelisting, or in case of Deny rules rejecting the CIDRs of all the ipBlock peers
+//An ipBlock with except ranges jumps to its own sub-chain of the provided chain of its IP family, where the excepted ranges are skipped by RETURN rules preceding the rule of the whole CIDR
+//This way the excepted ranges are only skipped by the ipBlock itself: they can still be matched by the later rules of the chain, so policies stay additive just like in upstream
+//When the ipBlock is combined with a network selector, the rules only match the CIDR on the interfaces of the isolated Pod connected to the selected networks
+func parseIpBlockRules(depSet *poltypes.DanmEpSet, peers []polv1.NetworkPolicyPeer, ports []polv1.NetworkPolicyPort, action polv1.RuleAction, parserFunc RuleParser, getPortPod portPodGetter, v4Chain, v6Chain *poltypes.NetRuleChain) ([]poltypes.NetRule,[]poltypes.NetRule) {
   v4Rules := make([]poltypes.NetRule, 0)
   v6Rules := make([]poltypes.NetRule, 0)
+  netPorts, ok := resolvePorts(ports, nil, getPortPod)
+  if !ok {
+    return v4Rules, v6Rules
+  }
+  for _, peer := range peers {
+    if peer.IPBlock == nil {
+      continue
+    }
+    cidrIp, _, err := net.ParseCIDR(peer.IPBlock.CIDR)
+    if err != nil {
+      log.Println("WARNING: ipBlock CIDR:" + peer.IPBlock.CIDR + " is invalid, ignoring related peer!")
+      continue
+    }
+    isV4 := cidrIp.To4() != nil
+    familyChain := v6Chain
+    if isV4 {
+      familyChain = v4Chain
+    }
+    exceptChain := newExceptChain(familyChain.Name + poltypes.ExceptChainInfix + strconv.Itoa(len(familyChain.SubChains)), peer.IPBlock, isV4, action, parserFunc)
+    peerRules := make([]poltypes.NetRule, 0)
+    for _, iface := range selectPodIfaces(depSet, peer.NetworkSelector) {
+      rules := parserFunc(peer.IPBlock.CIDR, iface, netPorts)
+      if len(exceptChain.Rules) > 0 {
+        for i := range rules {
+          rules[i].Operation = exceptChain.Name
+        }
+      } else {
+        rules = applyAction(rules, action)
+      }
+      peerRules = append(peerRules, rules...)
+    }
+    //The sub-chain is only provisioned when there is a rule jumping to it, i.e. when the Pod is connected to any of the selected networks
+    if len(exceptChain.Rules) > 0 && len(peerRules) > 0 {
+      familyChain.SubChains = append(familyChain.SubChains, exceptChain)
+    }
+    if isV4 {
+      v4Rules = append(v4Rules, peerRules...)
+    } else {
+      v6Rules = append(v6Rules, peerRules...)
+    }
+  }
+  return v4Rules, v6Rules
+}
+
+//newExceptChain creates the sub-chain of an ipBlock with RETURN rules for its except ranges, followed by the rule accepting, or in case of Deny rules rejecting everything else
+//The rules jumping to the sub-chain already match the CIDR, the interfaces and the ports, so the rules of the sub-chain only need to match the excepted ranges
+//The returned chain does not have any rules when the ipBlock does not have valid except ranges, i.e. the sub-chain is not needed
+func newExceptChain(chainName string, ipBlock *polv1.IPBlock, isV4 bool, action polv1.RuleAction, parserFunc RuleParser) poltypes.NetRuleChain {
+  exceptChain := poltypes.NetRuleChain{Name: chainName, Rules: make([]poltypes.NetRule, 0)}
+  for _, except := range ipBlock.Except {
+    exceptIp, _, err := net.ParseCIDR(except)
+    if err != nil || (exceptIp.To4() != nil) != isV4 {
+      log.Println("WARNING: except range:" + except + " of ipBlock CIDR:" + ipBlock.CIDR + " is invalid, ignoring it!")
+      continue
+    }
+    for _, exceptRule := range parserFunc(except, "", nil) {
+      exceptRule.Operation = poltypes.IptablesReturn
+      exceptChain.Rules = append(exceptChain.Rules, exceptRule)
+    }
+  }
+  if len(exceptChain.Rules) == 0 {
+    return exceptChain
+  }
+  exceptChain.Rules = append(exceptChain.Rules, applyAction(parserFunc("", "", nil), action)...)
+  return exceptChain
+}
+
+//resolvePorts converts the ports of a policy rule to numbers, defaulting their protocol to TCP just like upstream
+//Named ports are looked-up from the container ports of the Pod returned by the portPodGetter, and are left out when they can't be resolved
+//Port ranges are represented in the "port:endPort" format, and are left out when they are invalid
+//The second return value is false when ports were defined, but none of them could be resolved, i.e. the rule can't whitelist anything
+func resolvePorts(ports []polv1.NetworkPolicyPort, dep *danmv1.DanmEp, getPortPod portPodGetter) ([]poltypes.NetPort,bool) {
+  netPorts := make([]poltypes.NetPort, 0)
+  var portPod *corev1.Pod
+  isPortPodFetched := false
+  for _, port := range ports {
+    protocol := corev1.ProtocolTCP
+    if port.Protocol != nil {
+      protocol = *port.Protocol
+    }
+    netPort := poltypes.NetPort{Protocol: strings.ToLower(string(protocol))}
+    if port.EndPort != nil {
+      if port.Port == nil || port.Port.Type != intstr.Int || *port.EndPort < port.Port.IntVal {
+        log.Println("WARNING: endPort:" + strconv.Itoa(int(*port.EndPort)) + " is only valid together with a numeric port not bigger than itself, ignoring it!")
+        continue
+      }
+      netPort.Port = strconv.Itoa(int(port.Port.IntVal)) + poltypes.PortRangeSeparator + strconv.Itoa(int(*port.EndPort))
+    } else if port.Port != nil && port.Port.Type == intstr.Int {
+      netPort.Port = strconv.Itoa(int(port.Port.IntVal))
+    } else if port.Port != nil {
+      //Peer Pods are only fetched when they are really needed
+      if !isPortPodFetched {
+        portPod, isPortPodFetched = getPortPod(dep), true
+      }
+      containerPort := getNamedContainerPort(portPod, port.Port.StrVal, protocol)
+      if containerPort == 0 {
+        continue
+      }
+      netPort.Port = strconv.Itoa(int(containerPort))
+    }
+    netPorts = append(netPorts, netPort)
+  }
+  return netPorts, len(ports) == 0 || len(netPorts) > 0
+}
+
+func getNamedContainerPort(pod *corev1.Pod, portName string, protocol corev1.Protocol) int32 {
+  if pod == nil {
+    return 0
+  }
+  for _, container := range pod.Spec.Containers {
+    for _, containerPort := range container.Ports {
+      containerProtocol := containerPort.Protocol
+      if containerProtocol == "" {
+        containerProtocol = corev1.ProtocolTCP
+      }
+      if containerPort.Name == portName && containerProtocol == protocol {
+        return containerPort.ContainerPort
+      }
+    }
+  }
+  return 0
+}
+
+//selectPodIfaces returns the names of the interfaces of the isolated Pod connected to any of the selected networks
+//It is used both for the target network selector of the policies, and for the network selector of the ipBlock peers
+//Missing network selector is represented by one empty name, meaning the rules are not restricted to any interfaces
+func selectPodIfaces(depSet *poltypes.DanmEpSet, networkSelectors []polv1.NetworkSelector) []string {
+  if len(networkSelectors) == 0 {
+    return []string{""}
+  }
+  ifaces := make([]string, 0)
+  for _, dep := range depSet.PodEps {
+    for _, netSelector := range networkSelectors {
+      if getNetworkBucketName(netSelector.Name, netSelector.Type) == getNetworkBucketName(dep.Spec.NetworkName, dep.Spec.ApiType) {
+        ifaces = append(ifaces, dep.Spec.Iface.Name)
+        break
+      }
+    }
+  }
+  return ifaces
+}
+
+func getNetworkBucketName(name, apiType string) string {
+  if apiType == "" {
+    return name + poltypes.DanmNetKind
+  }
+  return name + apiType
+}
+
+//IsDanmEpSelected tells whether any of the from, or to peers of the provided policies select the DanmEp
+//Labels of the DanmEp's namespace are required to evaluate the namespace selectors of the peers
+func IsDanmEpSelected(polSet []polv1.DanmNetworkPolicy, dep danmv1.DanmEp, namespaceLabels map[string]string) bool {
+  depSet := depset.NewPeerDanmEpSet([]danmv1.DanmEp{dep}, map[string]map[string]string{dep.ObjectMeta.Namespace: namespaceLabels})
+  for _, policy := range polSet {
+    for _, ingressRule := range policy.Spec.Ingress {
+      if len(selectPeerDeps(depSet, policy.ObjectMeta.Namespace, ingressRule.From)) > 0 {
+        return true
+      }
+    }
+    for _, egressRule := range policy.Spec.Egress {
+      if len(selectPeerDeps(depSet, policy.ObjectMeta.Namespace, egressRule.To)) > 0 {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+//selectPeerDeps returns all the DanmEps selected by any of the peers
+//Peers select DanmEps from the policy's own namespace, unless they explicitly select other namespaces
+//Selectors within the same peer are restrictive, so the final set of a peer is the intersection of all the sets selected by its selectors
+func selectPeerDeps(depSet *poltypes.DanmEpSet, namespace string, peers []polv1.NetworkPolicyPeer) []danmv1.DanmEp {
+  selectedDeps := make([]danmv1.DanmEp, 0)
+  depCache := make(poltypes.UidCache, 0)
   for _, peer := range peers {
-    //TODO: before we even get to rule filtering phase we should handle the corner-cases
-    //1: peer list key is provided but empty list -> EVERYTHING is whitelisted
-    //2: peer list is missing -> NOTHING is whitelisted
-    //Only when peer list is provided and at least one selector is present we should progress to filtering
-    podSelectedDeps     := filterDepsByPodSelector(depSet, peer.PodSelector)
-    networkSelectedDeps := filterDepsByNetworkSelector(depSet, peer.NetworkSelector)
-    finalDeps := intersectDepSets(podSelectedDeps, networkSelectedDeps)
-    depCache := make(poltypes.UidCache, 0)
+    //ipBlock peers whitelist addresses, not Pods
+    if peer.IPBlock != nil {
+      continue
+    }
+    namespaceSelectedDeps := filterDepsByNamespaceSelector(depSet, namespace, peer.NamespaceSelector)
+    podSelectedDeps       := filterDepsByPodSelector(depSet, peer.PodSelector)
+    networkSelectedDeps   := filterDepsByNetworkSelector(depSet, peer.NetworkSelector)
+    //Peers without any selectors whitelist all addresses, rather than selecting DanmEps
+    if peer.NamespaceSelector == nil && podSelectedDeps == nil && networkSelectedDeps == nil {
+      continue
+    }
+    finalDeps := intersectDepSets(intersectDepSets(namespaceSelectedDeps, podSelectedDeps), networkSelectedDeps)
     for _, dep := range finalDeps {
       if _, ok := depCache[dep.ObjectMeta.UID]; !ok {
         depCache[dep.ObjectMeta.UID] = true
-        if dep.Spec.Iface.Address != "" && dep.Spec.Iface.Address != ipam.NoneAllocType {
-          v4Rules = append(v4Rules, parserFunc(dep.Spec.Iface.Address, ports)...)
-        }
-        if dep.Spec.Iface.AddressIPv6 != "" && dep.Spec.Iface.AddressIPv6 != ipam.NoneAllocType {
-          v6Rules = append(v6Rules, parserFunc(dep.Spec.Iface.AddressIPv6, ports)...)
-        }
+        selectedDeps = append(selectedDeps, dep)
       }
     }
   }
-  return v4Rules, v6Rules
+  return selectedDeps
 }
 
-func filterDepsByPodSelector(depSet *poltypes.DanmEpSet, podSelector metav1.LabelSelector) []danmv1.DanmEp {
+//filterDepsByNamespaceSelector returns the DanmEps of all the namespaces selected by the selector
+//Missing namespace selector selects the policy's own namespace, while an empty one selects all namespaces, just like in upstream
+func filterDepsByNamespaceSelector(depSet *poltypes.DanmEpSet, namespace string, namespaceSelector *metav1.LabelSelector) []danmv1.DanmEp {
   selectedDeps := make([]danmv1.DanmEp, 0)
+  if namespaceSelector == nil {
+    return append(selectedDeps, depSet.DanmEpsByNamespace.Get(namespace)...)
+  }
+  selector, err := metav1.LabelSelectorAsSelector(namespaceSelector)
+  if err != nil {
+    log.Println("WARNING: NamespaceSelector parsing failed with error:" + err.Error() + ", ignoring related peers!")
+    return selectedDeps
+  }
+  //Namespaces are visited in a fixed order, so the same peers always result in the same rules
+  namespaceNames := make([]string, 0)
+  for namespaceName := range depSet.NamespaceLabels {
+    namespaceNames = append(namespaceNames, namespaceName)
+  }
+  sort.Strings(namespaceNames)
+  for _, namespaceName := range namespaceNames {
+    if selector.Matches(labels.Set(depSet.NamespaceLabels[namespaceName])) {
+      selectedDeps = append(selectedDeps, depSet.DanmEpsByNamespace.Get(namespaceName)...)
+    }
+  }
+  return selectedDeps
+}
+
+//filterDepsByPodSelector returns nil when the peer does not filter based on Pod labels, and an empty list when it filters, but no DanmEps match
+func filterDepsByPodSelector(depSet *poltypes.DanmEpSet, podSelector metav1.LabelSelector) []danmv1.DanmEp {
   //Empty Pod selector in a non-empty peer means no Pods are whitelisted based on labels, whitelisting purely happens based on other selectors
-  if len(podSelector.MatchLabels) == 0 {
+  if len(podSelector.MatchLabels) == 0 && len(podSelector.MatchExpressions) == 0 {
+    return nil
+  }
+  selectedDeps := make([]danmv1.DanmEp, 0)
+  //matchExpressions can't be looked-up from the label index, so the selector is evaluated against every DanmEp one-by-one
+  if len(podSelector.MatchExpressions) > 0 {
+    selector, err := metav1.LabelSelectorAsSelector(&podSelector)
+    if err != nil {
+      log.Println("WARNING: PodSelector parsing failed with error:" + err.Error() + ", ignoring related peers!")
+      return selectedDeps
+    }
+    for _, dep := range depSet.DanmEpsByNamespace.List() {
+      if selector.Matches(labels.Set(dep.ObjectMeta.Labels)) {
+        selectedDeps = append(selectedDeps, dep)
+      }
+    }
     return selectedDeps
   }
   selectors, err := metav1.LabelSelectorAsMap(&podSelector)
@@ -74,32 +484,43 @@ func filterDepsByPodSelector(depSet *poltypes.DanmEpSet, podSelector metav1.Labe
     log.Println("WARNING: PodSelector parsing failed with error:" + err.Error() + ", ignoring related peers!")
     return selectedDeps
   }
+  //A DanmEp is only selected when all the labels match, so it is enough to verify the members of the smallest label bucket
+  var candidateDeps []danmv1.DanmEp
+  isFirstBucket := true
   for key, value := range selectors {
-    selectedDeps = append(selectedDeps, depSet.DanmEpsByLabel[key+value+poltypes.CustomBucketPostfix]...)
+    labelDeps := depSet.DanmEpsByLabel.Get(key+value+poltypes.CustomBucketPostfix)
+    if isFirstBucket || len(labelDeps) < len(candidateDeps) {
+      candidateDeps = labelDeps
+      isFirstBucket = false
+    }
+  }
+  selector := labels.SelectorFromSet(selectors)
+  for _, dep := range candidateDeps {
+    if selector.Matches(labels.Set(dep.ObjectMeta.Labels)) {
+      selectedDeps = append(selectedDeps, dep)
+    }
   }
   return selectedDeps
 }
 
+//filterDepsByNetworkSelector returns nil when the peer does not filter based on networks, and an empty list when it filters, but no DanmEps match
 func filterDepsByNetworkSelector(depSet *poltypes.DanmEpSet, networkSelectors []polv1.NetworkSelector) []danmv1.DanmEp {
-   selectedDeps := make([]danmv1.DanmEp, 0)
   //Empty network selector in a non-empty peer means we don't filter by networks, whitelisting happens purely based on other selectors
   if len(networkSelectors) == 0 {
-    return selectedDeps
+    return nil
   }
+  selectedDeps := make([]danmv1.DanmEp, 0)
   for _, netSelector := range networkSelectors {
-    networkBucketName := netSelector.Name + netSelector.Type
-    if netSelector.Type == "" {
-      networkBucketName += poltypes.DanmNetKind
-    }
-    selectedDeps = append(selectedDeps, depSet.DanmEpsByNetwork[networkBucketName]...)
+    selectedDeps = append(selectedDeps, depSet.DanmEpsByNetwork.Get(getNetworkBucketName(netSelector.Name, netSelector.Type))...)
   }
   return selectedDeps
 }
 
+//intersectDepSets treats a nil set as a selector which does not filter at all
 func intersectDepSets(firstSet, secondSet []danmv1.DanmEp) []danmv1.DanmEp {
-  if len(firstSet) == 0 {
+  if firstSet == nil {
     return secondSet
-  } else if len(secondSet) == 0 {
+  } else if secondSet == nil {
     return firstSet
   }
   secondSetIndex := make(poltypes.UidCache, 0)
@@ -118,29 +539,30 @@ func intersectDepSets(firstSet, secondSet []danmv1.DanmEp) []danmv1.DanmEp {
   return intersectedDeps
 }
 
-func newIngressNetRules(address string, ports []networking.NetworkPolicyPort) []poltypes.NetRule {
+func newIngressNetRules(address, iface string, ports []poltypes.NetPort) []poltypes.NetRule {
   ingressRules := make([]poltypes.NetRule, 0)
   if len(ports) == 0 {
-    universalRule := poltypes.NetRule{SourceIp: strings.Split(address, "/")[0]}
+    universalRule := poltypes.NetRule{SourceIp: address, SourceIface: iface}
     ingressRules = append(ingressRules, universalRule)
     return ingressRules
   }
   for _, port := range ports {
-    ingressRule := poltypes.NetRule{SourceIp: strings.Split(address, "/")[0], SourcePort: port.Port.StrVal, Protocol: string(*port.Protocol)}
+    //Ingress ports are the ports the isolated Pod listens on, peers connect from any ephemeral source port
+    ingressRule := poltypes.NetRule{SourceIp: address, SourceIface: iface, DestPort: port.Port, Protocol: port.Protocol}
     ingressRules = append(ingressRules, ingressRule)
   }
   return ingressRules
 }
 
-func newEgressNetRules(address string, ports []networking.NetworkPolicyPort) []poltypes.NetRule {
+func newEgressNetRules(address, iface string, ports []poltypes.NetPort) []poltypes.NetRule {
   egressRules := make([]poltypes.NetRule, 0)
   if len(ports) == 0 {
-    universalRule := poltypes.NetRule{DestIp: strings.Split(address, "/")[0]}
+    universalRule := poltypes.NetRule{DestIp: address, DestIface: iface}
     egressRules = append(egressRules, universalRule)
     return egressRules
   }
   for _, port := range ports {
-    egressRule := poltypes.NetRule{DestIp: strings.Split(address, "/")[0], DestPort: port.Port.StrVal, Protocol: string(*port.Protocol)}
+    egressRule := poltypes.NetRule{DestIp: address, DestIface: iface, DestPort: port.Port, Protocol: port.Protocol}
     egressRules = append(egressRules, egressRule)
   }
   return egressRules
@@ -0,0 +1,463 @@
+package netruleset
+
+import (
+  "bytes"
+  "errors"
+  "flag"
+  "io/ioutil"
+  "path/filepath"
+  "sort"
+  "strconv"
+  "strings"
+  "testing"
+  danmv1 "github.com/nokia/danm/crd/apis/danm/v1"
+  polv1 "github.com/nokia/danm-utils/crd/api/netpol/v1"
+  "github.com/nokia/danm-utils/pkg/depset"
+  "github.com/nokia/danm-utils/types/poltypes"
+  corev1 "k8s.io/api/core/v1"
+  metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+  "k8s.io/apimachinery/pkg/runtime"
+  "k8s.io/apimachinery/pkg/types"
+  "k8s.io/apimachinery/pkg/util/intstr"
+  corelisters "k8s.io/client-go/listers/core/v1"
+  "k8s.io/client-go/tools/cache"
+  "k8s.io/kubernetes/pkg/apis/networking"
+)
+
+const (
+  testNamespace = "default"
+)
+
+var (
+  update = flag.Bool("update", false, "update the golden files of TestGoldenRuleSets")
+  testPod = &corev1.Pod {
+    ObjectMeta: metav1.ObjectMeta{Name: "isolated", Namespace: testNamespace, UID: types.UID("isolated")},
+    Spec: corev1.PodSpec{Containers: []corev1.Container{{Ports: []corev1.ContainerPort {
+      {Name: "http", ContainerPort: 8080},
+      {Name: "dns", ContainerPort: 5353, Protocol: corev1.ProtocolUDP},
+    }}}},
+  }
+  testPeerPods = map[string]*corev1.Pod {
+    "db-prod": &corev1.Pod {
+      ObjectMeta: metav1.ObjectMeta{Name: "db-prod", Namespace: testNamespace, UID: types.UID("db-prod")},
+      Spec: corev1.PodSpec{Containers: []corev1.Container{{Ports: []corev1.ContainerPort{{Name: "sql", ContainerPort: 5432}}}}},
+    },
+  }
+  testDeps = []runtime.Object {
+    newTestDep("isolated", "10.0.0.10/24", map[string]string{}),
+    newTestPodDep("isolated-oam", "isolated", "oam", "eth1", "10.1.0.10/24"),
+    newTestDep("db-prod", "10.0.0.1/24", map[string]string{"app": "db", "tier": "prod"}),
+    newTestDep("db-dev", "10.0.0.2/24", map[string]string{"app": "db", "tier": "dev"}),
+    newTestDep("web-prod", "10.0.0.3/24", map[string]string{"app": "web", "tier": "prod"}),
+    newTestDep("db", "10.0.0.4/24", map[string]string{"app": "db"}),
+    newTestDualStackDep("lb", "10.0.0.5/24", "fd00::5/64", map[string]string{"role": "lb"}),
+  }
+)
+
+var peerSelectionTcs = []struct {
+  tcName string
+  podSelector metav1.LabelSelector
+  expectedIps []string
+}{
+  {"singleLabel", metav1.LabelSelector{MatchLabels: map[string]string{"tier": "prod"}}, []string{"10.0.0.1", "10.0.0.3"}},
+  {"allLabelsMustMatch", metav1.LabelSelector{MatchLabels: map[string]string{"app": "db", "tier": "prod"}}, []string{"10.0.0.1"}},
+  {"noPeerHasAllLabels", metav1.LabelSelector{MatchLabels: map[string]string{"app": "web", "tier": "dev"}}, []string{}},
+  {"unknownLabel", metav1.LabelSelector{MatchLabels: map[string]string{"app": "db", "zone": "a"}}, []string{}},
+  {"labelsAndExpressions", metav1.LabelSelector{
+    MatchLabels: map[string]string{"app": "db"},
+    MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tier", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"dev"}}},
+  }, []string{"10.0.0.1", "10.0.0.4"}},
+  {"onlyExpressions", metav1.LabelSelector{
+    MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tier", Operator: metav1.LabelSelectorOpExists}},
+  }, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+}
+
+func TestPeerSelection(t *testing.T) {
+  depIndexer := newTestDanmEpIndexer(testDeps...)
+  for _, tc := range peerSelectionTcs {
+    t.Run(tc.tcName, func(t *testing.T) {
+      policy := polv1.DanmNetworkPolicy {
+        ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testNamespace, UID: types.UID("policy")},
+        Spec: polv1.NetPolSpec {
+          Ingress: []polv1.NetworkPolicyIngressRule{{From: []polv1.NetworkPolicyPeer{{PodSelector: tc.podSelector}}}},
+        },
+      }
+      polSet := []polv1.DanmNetworkPolicy{policy}
+      depSet := depset.NewDanmEpSet(depIndexer, newTestNamespaceLister(), testPod)
+      ruleSet := NewNetRuleSet(polSet, depSet, testPod, getTestPod)
+      sourceIps := getSourceIps(ruleSet.IngressV4Chain)
+      if !isEqual(sourceIps, tc.expectedIps) {
+        t.Errorf("Whitelisted peers:%v do not match the expected:%v", sourceIps, tc.expectedIps)
+      }
+    })
+  }
+}
+
+var portTcs = []struct {
+  tcName string
+  isEgress bool
+  ports []polv1.NetworkPolicyPort
+  expectedPorts []string
+}{
+  {"numericPort", false, []polv1.NetworkPolicyPort{{Port: newIntPort(80)}}, []string{"tcp:80"}},
+  {"protocolWithoutPort", false, []polv1.NetworkPolicyPort{{Protocol: newProtocol(corev1.ProtocolUDP)}}, []string{"udp:"}},
+  {"explicitProtocol", false, []polv1.NetworkPolicyPort{{Protocol: newProtocol(corev1.ProtocolSCTP), Port: newIntPort(36412)}}, []string{"sctp:36412"}},
+  {"namedIngressPort", false, []polv1.NetworkPolicyPort{{Port: newNamedPort("http")}}, []string{"tcp:8080"}},
+  {"namedIngressPortWithProtocol", false, []polv1.NetworkPolicyPort{{Protocol: newProtocol(corev1.ProtocolUDP), Port: newNamedPort("dns")}}, []string{"udp:5353"}},
+  {"namedIngressPortWrongProtocol", false, []polv1.NetworkPolicyPort{{Port: newNamedPort("dns")}}, []string{}},
+  {"unknownNamedPortIsSkipped", false, []polv1.NetworkPolicyPort{{Port: newNamedPort("unknown")}, {Port: newIntPort(80)}}, []string{"tcp:80"}},
+  {"namedEgressPort", true, []polv1.NetworkPolicyPort{{Port: newNamedPort("sql")}}, []string{"tcp:5432"}},
+  {"namedEgressPortOfIsolatedPod", true, []polv1.NetworkPolicyPort{{Port: newNamedPort("http")}}, []string{}},
+  {"portRange", false, []polv1.NetworkPolicyPort{{Protocol: newProtocol(corev1.ProtocolUDP), Port: newIntPort(10000), EndPort: newEndPort(20000)}}, []string{"udp:10000:20000"}},
+  {"singlePortRange", true, []polv1.NetworkPolicyPort{{Port: newIntPort(80), EndPort: newEndPort(80)}}, []string{"tcp:80:80"}},
+  {"invalidPortRange", false, []polv1.NetworkPolicyPort{{Port: newIntPort(20000), EndPort: newEndPort(10000)}, {Port: newIntPort(80)}}, []string{"tcp:80"}},
+  {"namedPortRange", false, []polv1.NetworkPolicyPort{{Port: newNamedPort("http"), EndPort: newEndPort(9000)}}, []string{}},
+}
+
+func TestPorts(t *testing.T) {
+  depIndexer := newTestDanmEpIndexer(testDeps...)
+  peers := []polv1.NetworkPolicyPeer{{PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db", "tier": "prod"}}}}
+  for _, tc := range portTcs {
+    t.Run(tc.tcName, func(t *testing.T) {
+      policy := polv1.DanmNetworkPolicy {
+        ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testNamespace, UID: types.UID("policy")},
+      }
+      if tc.isEgress {
+        policy.Spec.Egress = []polv1.NetworkPolicyEgressRule{{To: peers, Ports: tc.ports}}
+      } else {
+        policy.Spec.Ingress = []polv1.NetworkPolicyIngressRule{{From: peers, Ports: tc.ports}}
+      }
+      polSet := []polv1.DanmNetworkPolicy{policy}
+      depSet := depset.NewDanmEpSet(depIndexer, newTestNamespaceLister(), testPod)
+      ruleSet := NewNetRuleSet(polSet, depSet, testPod, getTestPod)
+      chain := ruleSet.IngressV4Chain
+      if tc.isEgress {
+        chain = ruleSet.EgressV4Chain
+      }
+      ports := getPorts(chain)
+      if !isEqual(ports, tc.expectedPorts) {
+        t.Errorf("Whitelisted ports:%v do not match the expected:%v", ports, tc.expectedPorts)
+      }
+    })
+  }
+}
+
+var goldenTcs = []struct {
+  tcName string
+  targetNetworks []polv1.NetworkSelector
+  policyTypes []networking.PolicyType
+  ingress []polv1.NetworkPolicyIngressRule
+  egress []polv1.NetworkPolicyEgressRule
+  //otherPolicy is an optional second policy selecting the Pod with the same priority
+  otherPolicy *polv1.NetPolSpec
+}{
+  {"ingress_ports", nil, nil, []polv1.NetworkPolicyIngressRule{{
+    From: []polv1.NetworkPolicyPeer{{PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"role": "lb"}}}},
+    Ports: []polv1.NetworkPolicyPort {
+      {Port: newIntPort(80)},
+      {Port: newNamedPort("http")},
+      {Protocol: newProtocol(corev1.ProtocolUDP), Port: newIntPort(10000), EndPort: newEndPort(20000)},
+    },
+  }}, nil, nil},
+  {"egress_ports", nil, nil, nil, []polv1.NetworkPolicyEgressRule{{
+    To: []polv1.NetworkPolicyPeer{{PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"role": "lb"}}}},
+    Ports: []polv1.NetworkPolicyPort {
+      {Port: newIntPort(443)},
+      {Protocol: newProtocol(corev1.ProtocolSCTP)},
+    },
+  }}, nil},
+  {"ingress_no_ports", nil, nil, []polv1.NetworkPolicyIngressRule{{
+    From: []polv1.NetworkPolicyPeer{{PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"role": "lb"}}}},
+  }}, nil, nil},
+  {"ip_block", nil, nil, []polv1.NetworkPolicyIngressRule{{
+    From: []polv1.NetworkPolicyPeer {
+      {IPBlock: &polv1.IPBlock{CIDR: "192.168.0.0/16", Except: []string{"192.168.1.0/24", "fd00::/64"}}, NetworkSelector: []polv1.NetworkSelector{{Name: "internal"}}},
+      {PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"role": "lb"}}},
+    },
+    Ports: []polv1.NetworkPolicyPort{{Port: newIntPort(123), Protocol: newProtocol(corev1.ProtocolUDP)}},
+  }}, []polv1.NetworkPolicyEgressRule{{
+    To: []polv1.NetworkPolicyPeer{{IPBlock: &polv1.IPBlock{CIDR: "2001:db8::/32"}}},
+  }}, nil},
+  {"missing_peers", nil, nil, []polv1.NetworkPolicyIngressRule{{
+    Ports: []polv1.NetworkPolicyPort{{Port: newIntPort(80)}},
+  }}, nil, nil},
+  {"empty_peers", nil, nil, nil, []polv1.NetworkPolicyEgressRule{{To: []polv1.NetworkPolicyPeer{}}}, nil},
+  {"peer_without_selectors", nil, nil, []polv1.NetworkPolicyIngressRule{{
+    From: []polv1.NetworkPolicyPeer{{PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"role": "lb"}}}, {}},
+  }}, nil, nil},
+  {"deny_all_ingress", nil, nil, []polv1.NetworkPolicyIngressRule{}, nil, nil},
+  {"deny_all_egress", nil, []networking.PolicyType{networking.PolicyTypeEgress}, nil, nil, nil},
+  {"egress_policy_type_ignores_ingress", nil, []networking.PolicyType{networking.PolicyTypeEgress}, []polv1.NetworkPolicyIngressRule{{
+    From: []polv1.NetworkPolicyPeer{{PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"role": "lb"}}}},
+  }}, []polv1.NetworkPolicyEgressRule{{
+    To: []polv1.NetworkPolicyPeer{{PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"role": "lb"}}}},
+  }}, nil},
+  {"multiple_rules", nil, nil, []polv1.NetworkPolicyIngressRule {
+    {
+      From: []polv1.NetworkPolicyPeer{{PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"role": "lb"}}}},
+      Ports: []polv1.NetworkPolicyPort{{Port: newIntPort(80)}},
+    },
+    {
+      From: []polv1.NetworkPolicyPeer{{PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db", "tier": "prod"}}}},
+      Ports: []polv1.NetworkPolicyPort{{Port: newIntPort(5432)}},
+    },
+  }, []polv1.NetworkPolicyEgressRule {
+    {To: []polv1.NetworkPolicyPeer{{PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"role": "lb"}}}}},
+    {To: []polv1.NetworkPolicyPeer{{IPBlock: &polv1.IPBlock{CIDR: "172.16.0.0/12"}}}, Ports: []polv1.NetworkPolicyPort{{Port: newIntPort(443)}}},
+  }, nil},
+  {"target_network", []polv1.NetworkSelector{{Name: "oam"}}, nil, []polv1.NetworkPolicyIngressRule{{
+    From: []polv1.NetworkPolicyPeer {
+      {PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"role": "lb"}}},
+      {IPBlock: &polv1.IPBlock{CIDR: "192.168.0.0/16"}, NetworkSelector: []polv1.NetworkSelector{{Name: "internal"}}},
+      {IPBlock: &polv1.IPBlock{CIDR: "192.169.0.0/16"}, NetworkSelector: []polv1.NetworkSelector{{Name: "oam"}}},
+    },
+    Ports: []polv1.NetworkPolicyPort{{Port: newIntPort(80)}},
+  }}, []polv1.NetworkPolicyEgressRule{{}}, nil},
+  {"both_policy_types", nil, []networking.PolicyType{networking.PolicyTypeIngress, networking.PolicyTypeEgress}, nil, nil, nil},
+  {"deny_rules", nil, nil, []polv1.NetworkPolicyIngressRule {
+    {
+      Action: polv1.RuleActionDeny,
+      From: []polv1.NetworkPolicyPeer {
+        {PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db", "tier": "prod"}}},
+        {IPBlock: &polv1.IPBlock{CIDR: "192.168.5.5/32"}},
+      },
+    },
+    {
+      Action: polv1.RuleActionAllow,
+      From: []polv1.NetworkPolicyPeer {
+        {PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}},
+        {IPBlock: &polv1.IPBlock{CIDR: "192.168.0.0/16"}},
+      },
+    },
+  }, []polv1.NetworkPolicyEgressRule {
+    {Action: polv1.RuleActionDeny, To: []polv1.NetworkPolicyPeer{{IPBlock: &polv1.IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16"}}}}},
+    {},
+  }, nil},
+  {"overlapping_ip_blocks", nil, nil, []polv1.NetworkPolicyIngressRule {
+    {From: []polv1.NetworkPolicyPeer{{IPBlock: &polv1.IPBlock{CIDR: "192.168.0.0/16", Except: []string{"192.168.1.0/24", "192.168.2.0/24"}}}}},
+    {From: []polv1.NetworkPolicyPeer{{PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"role": "lb"}}}}, Ports: []polv1.NetworkPolicyPort{{Port: newIntPort(80)}}},
+  }, []polv1.NetworkPolicyEgressRule {
+    {Action: polv1.RuleActionDeny, To: []polv1.NetworkPolicyPeer{{IPBlock: &polv1.IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16"}}}}},
+    {To: []polv1.NetworkPolicyPeer{{IPBlock: &polv1.IPBlock{CIDR: "10.0.0.0/8"}}}, Ports: []polv1.NetworkPolicyPort{{Port: newIntPort(443)}}},
+  }, &polv1.NetPolSpec {
+    Ingress: []polv1.NetworkPolicyIngressRule {
+      {From: []polv1.NetworkPolicyPeer{{IPBlock: &polv1.IPBlock{CIDR: "192.168.1.0/24"}}}},
+      {From: []polv1.NetworkPolicyPeer{{IPBlock: &polv1.IPBlock{CIDR: "192.168.0.0/16", Except: []string{"192.168.2.0/24"}}}}, Ports: []polv1.NetworkPolicyPort{{Port: newIntPort(22)}}},
+    },
+  }},
+  {"ip_block_without_ifaces", nil, nil, []polv1.NetworkPolicyIngressRule{{
+    From: []polv1.NetworkPolicyPeer {
+      {IPBlock: &polv1.IPBlock{CIDR: "172.16.0.0/12", Except: []string{"172.16.1.0/24"}}, NetworkSelector: []polv1.NetworkSelector{{Name: "storage"}}},
+      {IPBlock: &polv1.IPBlock{CIDR: "192.168.0.0/16", Except: []string{"192.168.1.0/24"}}, NetworkSelector: []polv1.NetworkSelector{{Name: "oam"}}},
+    },
+  }}, nil, nil},
+}
+
+//TestGoldenRuleSets compares the rules calculated for both directions to the content of the testdata/<tcName>.golden files
+//The golden files can be regenerated with: go test ./pkg/netruleset -run TestGoldenRuleSets -update
+func TestGoldenRuleSets(t *testing.T) {
+  depIndexer := newTestDanmEpIndexer(testDeps...)
+  for _, tc := range goldenTcs {
+    t.Run(tc.tcName, func(t *testing.T) {
+      policy := polv1.DanmNetworkPolicy {
+        ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testNamespace, UID: types.UID("policy")},
+        Spec: polv1.NetPolSpec{Ingress: tc.ingress, Egress: tc.egress, PolicyTypes: tc.policyTypes, TargetNetworkSelector: tc.targetNetworks},
+      }
+      polSet := []polv1.DanmNetworkPolicy{policy}
+      if tc.otherPolicy != nil {
+        polSet = append(polSet, polv1.DanmNetworkPolicy {
+          ObjectMeta: metav1.ObjectMeta{Name: "other-policy", Namespace: testNamespace, UID: types.UID("other-policy")},
+          Spec: *tc.otherPolicy,
+        })
+      }
+      depSet := depset.NewDanmEpSet(depIndexer, newTestNamespaceLister(), testPod)
+      ruleSet := NewNetRuleSet(polSet, depSet, testPod, getTestPod)
+      var rendered bytes.Buffer
+      rendered.WriteString("ingress isolated:" + strconv.FormatBool(ruleSet.IsIngressIsolated) + " on:" + getIfaces(ruleSet.IngressIfaces) +
+        " egress isolated:" + strconv.FormatBool(ruleSet.IsEgressIsolated) + " on:" + getIfaces(ruleSet.EgressIfaces) + "\n")
+      for _, chain := range []poltypes.NetRuleChain{ruleSet.IngressV4Chain, ruleSet.IngressV6Chain, ruleSet.EgressV4Chain, ruleSet.EgressV6Chain} {
+        renderChain(&rendered, chain)
+        for _, subChain := range chain.SubChains {
+          renderChain(&rendered, subChain)
+        }
+      }
+      goldenFile := filepath.Join("testdata", tc.tcName + ".golden")
+      if *update {
+        err := ioutil.WriteFile(goldenFile, rendered.Bytes(), 0644)
+        if err != nil {
+          t.Fatalf("Golden file could not be updated because:%v", err)
+        }
+      }
+      expected, err := ioutil.ReadFile(goldenFile)
+      if err != nil {
+        t.Fatalf("Golden file could not be read because:%v", err)
+      }
+      if !bytes.Equal(rendered.Bytes(), expected) {
+        t.Errorf("Calculated rules:\n%s\ndo not match the golden ones:\n%s", rendered.String(), string(expected))
+      }
+    })
+  }
+}
+
+func renderChain(rendered *bytes.Buffer, chain poltypes.NetRuleChain) {
+  rendered.WriteString(chain.Name + "\n")
+  for _, rule := range chain.Rules {
+    ruleStr := strings.TrimSpace(rule.String())
+    if ruleStr == "" {
+      ruleStr = "any"
+    }
+    rendered.WriteString("  " + ruleStr + "\n")
+  }
+}
+
+var priorityTcs = []struct {
+  tcName string
+  priorities []int32
+  expectedRules []string
+}{
+  {"samePriorityKeepsOrder", []int32{0, 0}, []string{"ACCEPT:10.0.0.5", "ACCEPT:192.168.0.0/16", "REJECT:10.0.0.5"}},
+  {"lowerPriorityFirst", []int32{10, 1}, []string{"REJECT:10.0.0.5", "ACCEPT:10.0.0.5", "ACCEPT:192.168.0.0/16"}},
+  {"higherPriorityLast", []int32{1, 10}, []string{"ACCEPT:10.0.0.5", "ACCEPT:192.168.0.0/16", "REJECT:10.0.0.5"}},
+}
+
+func TestRulePriority(t *testing.T) {
+  depIndexer := newTestDanmEpIndexer(testDeps...)
+  lbPeer := polv1.NetworkPolicyPeer{PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"role": "lb"}}}
+  for _, tc := range priorityTcs {
+    t.Run(tc.tcName, func(t *testing.T) {
+      allowPolicy := polv1.DanmNetworkPolicy {
+        ObjectMeta: metav1.ObjectMeta{Name: "allow", Namespace: testNamespace, UID: types.UID("allow")},
+        Spec: polv1.NetPolSpec{Priority: tc.priorities[0], Ingress: []polv1.NetworkPolicyIngressRule{{
+          From: []polv1.NetworkPolicyPeer{lbPeer, {IPBlock: &polv1.IPBlock{CIDR: "192.168.0.0/16"}}},
+        }}},
+      }
+      denyPolicy := polv1.DanmNetworkPolicy {
+        ObjectMeta: metav1.ObjectMeta{Name: "deny", Namespace: testNamespace, UID: types.UID("deny")},
+        Spec: polv1.NetPolSpec{Priority: tc.priorities[1], Ingress: []polv1.NetworkPolicyIngressRule{{
+          Action: polv1.RuleActionDeny, From: []polv1.NetworkPolicyPeer{lbPeer},
+        }}},
+      }
+      polSet := []polv1.DanmNetworkPolicy{allowPolicy, denyPolicy}
+      depSet := depset.NewDanmEpSet(depIndexer, newTestNamespaceLister(), testPod)
+      ruleSet := NewNetRuleSet(polSet, depSet, testPod, getTestPod)
+      rules := make([]string, 0)
+      for _, rule := range ruleSet.IngressV4Chain.Rules {
+        operation := rule.Operation
+        if operation == "" {
+          operation = poltypes.IptablesAccept
+        }
+        rules = append(rules, operation + ":" + rule.SourceIp)
+      }
+      if !isEqual(rules, tc.expectedRules) {
+        t.Errorf("Rules:%v are not in the expected order:%v", rules, tc.expectedRules)
+      }
+    })
+  }
+}
+
+func newTestDep(name, address string, labels map[string]string) *danmv1.DanmEp {
+  return &danmv1.DanmEp {
+    ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, UID: types.UID(name), Labels: labels},
+    Spec: danmv1.DanmEpSpec {
+      NetworkName: "internal",
+      Pod: name,
+      PodUID: types.UID(name),
+      Netns: "/var/run/netns/" + name,
+      Iface: danmv1.DanmEpIface{Name: "eth0", Address: address},
+    },
+  }
+}
+
+func newTestDualStackDep(name, address, addressIPv6 string, labels map[string]string) *danmv1.DanmEp {
+  dep := newTestDep(name, address, labels)
+  dep.Spec.Iface.AddressIPv6 = addressIPv6
+  return dep
+}
+
+func newTestPodDep(name, podName, network, iface, address string) *danmv1.DanmEp {
+  dep := newTestDep(name, address, map[string]string{})
+  dep.Spec.Pod, dep.Spec.PodUID = podName, types.UID(podName)
+  dep.Spec.Netns = "/var/run/netns/" + podName
+  dep.Spec.NetworkName, dep.Spec.Iface.Name = network, iface
+  return dep
+}
+
+func getIfaces(ifaces []string) string {
+  if len(ifaces) == 0 {
+    return "all"
+  }
+  return strings.Join(ifaces, ",")
+}
+
+func getTestPod(namespace, name string, uid types.UID) (*corev1.Pod, error) {
+  if pod, ok := testPeerPods[name]; ok {
+    return pod, nil
+  }
+  return nil, errors.New("pod:" + name + " not found")
+}
+
+func newIntPort(port int) *intstr.IntOrString {
+  intPort := intstr.FromInt(port)
+  return &intPort
+}
+
+func newNamedPort(port string) *intstr.IntOrString {
+  namedPort := intstr.FromString(port)
+  return &namedPort
+}
+
+func newEndPort(port int32) *int32 {
+  return &port
+}
+
+func newProtocol(protocol corev1.Protocol) *corev1.Protocol {
+  return &protocol
+}
+
+func newTestDanmEpIndexer(deps ...runtime.Object) cache.Indexer {
+  depIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, depset.Indexers)
+  for _, dep := range deps {
+    depIndexer.Add(dep)
+  }
+  return depIndexer
+}
+
+func newTestNamespaceLister() corelisters.NamespaceLister {
+  indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+  indexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: testNamespace}})
+  return corelisters.NewNamespaceLister(indexer)
+}
+
+func getSourceIps(chain poltypes.NetRuleChain) []string {
+  ips := make([]string, 0)
+  for _, rule := range chain.Rules {
+    ips = append(ips, rule.SourceIp)
+  }
+  sort.Strings(ips)
+  return ips
+}
+
+func getPorts(chain poltypes.NetRuleChain) []string {
+  ports := make([]string, 0)
+  for _, rule := range chain.Rules {
+    port := rule.DestPort
+    if port == "" {
+      port = rule.SourcePort
+    }
+    ports = append(ports, rule.Protocol + ":" + port)
+  }
+  sort.Strings(ports)
+  return ports
+}
+
+func isEqual(values, expectedValues []string) bool {
+  if len(values) != len(expectedValues) {
+    return false
+  }
+  for i := range values {
+    if values[i] != expectedValues[i] {
+      return false
+    }
+  }
+  return true
+}
\ No newline at end of file
@@ -4,47 +4,125 @@ import (
   "context"
   "errors"
   "io"
+  "fmt"
   "log"
   "os"
+  "strconv"
+  "sync"
   "time"
+  danmv1 "github.com/nokia/danm/crd/apis/danm/v1"
   danmclientset "github.com/nokia/danm/crd/client/clientset/versioned"
+  danminformers "github.com/nokia/danm/crd/client/informers/externalversions"
+  polv1 "github.com/nokia/danm-utils/crd/api/netpol/v1"
   polclientset "github.com/nokia/danm-utils/crd/client/clientset/versioned"
   polinformers "github.com/nokia/danm-utils/crd/client/informers/externalversions"
+  pollisters "github.com/nokia/danm-utils/crd/client/listers/netpol/v1"
   "github.com/nokia/danm-utils/pkg/depset"
   "github.com/nokia/danm-utils/pkg/netruleset"
   "github.com/nokia/danm-utils/pkg/polset"
   "github.com/nokia/danm-utils/pkg/provisioner/iptables"
+  "github.com/nokia/danm-utils/pkg/provisioner/nftables"
   "github.com/nokia/danm-utils/types/poltypes"
   corev1 "k8s.io/api/core/v1"
   metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+  "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
   apierrors "k8s.io/apimachinery/pkg/api/errors"
+  "k8s.io/apimachinery/pkg/fields"
+  "k8s.io/apimachinery/pkg/labels"
+  "k8s.io/apimachinery/pkg/runtime/schema"
+  "k8s.io/apimachinery/pkg/types"
+  "k8s.io/apimachinery/pkg/util/runtime"
+  "k8s.io/apimachinery/pkg/util/wait"
   kubeinformers "k8s.io/client-go/informers"
+  "k8s.io/client-go/dynamic"
+  "k8s.io/client-go/dynamic/dynamicinformer"
+  "k8s.io/client-go/dynamic/dynamiclister"
   "k8s.io/client-go/rest"
   "k8s.io/client-go/kubernetes"
+  corelisters "k8s.io/client-go/listers/core/v1"
   "k8s.io/client-go/tools/cache"
+  "k8s.io/client-go/util/workqueue"
 )
 
 const(
   MaxRetryCount = 5
   ShortRetryInterval = 100
-  LongRetryInterval = 500
+  MaxRequeueCount = 15
   NodeNameEnv = "NODE_NAME"
+  IptablesProvisionerName = "iptables"
+  NftablesProvisionerName = "nftables"
 )
 
 var (
   ControllerNode = os.Getenv(NodeNameEnv)
+  NetworkPolicyResource = schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "networkpolicies"}
 )
 
+//ControllerConfig holds the user tunable parameters of the Policer
+type ControllerConfig struct {
+  //RuleProvisioner selects the backend programming the isolation rules into the network namespace of the Pods
+  RuleProvisioner       string
+  //MirrorNetworkPolicies enables the enforcement of upstream NetworkPolicies in the namespaces opting in via annotation
+  MirrorNetworkPolicies bool
+  //ResyncInterval is the period of comparing the rules of all local Pods with their policies. Zero means only once, after startup
+  ResyncInterval        time.Duration
+}
+
 type NetPolControl struct {
-  PolicyController cache.SharedIndexInformer
-  PodController    cache.SharedIndexInformer
-  PolicyClient     polclientset.Interface
-  DanmClient       danmclientset.Interface
-  StopChan         *chan struct{}
+  PolicyController        cache.SharedIndexInformer
+  //ClusterPolicyController is only created when the ClusterDanmNetworkPolicy API is installed in the cluster
+  ClusterPolicyController cache.SharedIndexInformer
+  ClusterPolicyLister     pollisters.ClusterDanmNetworkPolicyLister
+  //NetworkPolicyController is only created when mirroring of upstream NetworkPolicies is enabled
+  //NetworkPolicies are cached as unstructured objects, so the fields our NetworkPolicy type does not know about -e.g. endPort- are not lost
+  NetworkPolicyController cache.SharedIndexInformer
+  NetworkPolicyLister     dynamiclister.Lister
+  //PodController only watches the Pods scheduled to the node of the Policer, peer Pods are known from their DanmEps
+  PodController           cache.SharedIndexInformer
+  PodLister               corelisters.PodLister
+  NamespaceController     cache.SharedIndexInformer
+  NamespaceLister         corelisters.NamespaceLister
+  DanmEpController        cache.SharedIndexInformer
+  KubeClient              kubernetes.Interface
+  DynamicClient           dynamic.Interface
+  PolicyClient            polclientset.Interface
+  DanmClient              danmclientset.Interface
+  RuleProvisioner         poltypes.RuleProvisioner
+  Workqueue               workqueue.RateLimitingInterface
+  //StatusWorkqueue contains the keys of the DanmNetworkPolicies whose status needs to be updated
+  StatusWorkqueue         workqueue.RateLimitingInterface
+  StopChan                *chan struct{}
+  ResyncInterval          time.Duration
+  //Keys of the Pods currently having Policer provisioned rules, so we know when isolation needs to be removed
+  isolatedPods            sync.Map
+  //Outcome of the last rule provisioning into the local Pods selected by any policy, reported in the status of the policies
+  provisioningResults     sync.Map
+  //Keys of the Pods whose rules need to be read back from their netns, and compared with their policies during their next provisioning
+  resyncPods              sync.Map
+  //Container ports of the remote peer Pods, keyed by their UID. Entries are removed together with the DanmEps of the Pods
+  peerPods                sync.Map
 }
 
-func NewNetPolControl(cfg *rest.Config, stopChan  *chan struct{}) (*NetPolControl,error) {
-  polControl := &NetPolControl{StopChan: stopChan}
+func NewNetPolControl(cfg *rest.Config, ctrlCfg ControllerConfig, stopChan  *chan struct{}) (*NetPolControl,error) {
+  //Without the name of the node no Pod would ever be considered local, and the Policer would silently do nothing
+  if ControllerNode == "" {
+    return nil, errors.New(NodeNameEnv + " environment variable is not set, it must contain the name of the node the Policer runs on")
+  }
+  ruleProvisioner, err := NewRuleProvisioner(ctrlCfg.RuleProvisioner)
+  if err != nil {
+    return nil, err
+  }
+  polControl := &NetPolControl{
+    StopChan:        stopChan,
+    ResyncInterval:  ctrlCfg.ResyncInterval,
+    RuleProvisioner: ruleProvisioner,
+    Workqueue:       workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Pods"),
+    StatusWorkqueue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "DanmNetworkPolicyStatuses"),
+  }
+  kubeClient, err := kubernetes.NewForConfig(cfg)
+  if err != nil {
+    return nil, err
+  }
   polClient, err := polclientset.NewForConfig(cfg)
   if err != nil {
     return nil, err
@@ -53,6 +131,12 @@ func NewNetPolControl(cfg *rest.Config, stopChan  *chan struct{}) (*NetPolContro
   if err != nil {
     return nil, err
   }
+  dynamicClient, err := dynamic.NewForConfig(cfg)
+  if err != nil {
+    return nil, err
+  }
+  polControl.KubeClient = kubeClient
+  polControl.DynamicClient = dynamicClient
   polControl.PolicyClient = polClient
   polControl.DanmClient = danmClient
   for i := 0; i < MaxRetryCount; i++ {
@@ -70,13 +154,65 @@ func NewNetPolControl(cfg *rest.Config, stopChan  *chan struct{}) (*NetPolContro
   if polControl.PolicyController == nil {
     return nil, errors.New("DanmNetworkPolicy API is not installed in the cluster, DANM Network Policy Controller cannot start!")
   }
-  polControl.createPodController(cfg)
+  //Cluster-wide policies are optional, the Policer works without their API too
+  _, err = polControl.PolicyClient.NetpolV1().ClusterDanmNetworkPolicies().List(context.TODO(), metav1.ListOptions{})
+  if err != nil {
+    log.Println("INFO: ClusterDanmNetworkPolicy discovery query failed with error:" + err.Error() + ", cluster-wide policies are not enforced")
+  } else {
+    polControl.createClusterPolicyController()
+  }
+  polControl.createPodController()
+  polControl.createDanmEpController()
+  if ctrlCfg.MirrorNetworkPolicies {
+    log.Println("INFO: NetworkPolicies are enforced in the namespaces annotated with " + poltypes.MirrorNetworkPoliciesAnnotation)
+    polControl.createNetworkPolicyController()
+  }
   return polControl, nil
 }
 
-func (netpolController *NetPolControl) Run() {
+//NewRuleProvisioner creates the rule provisioner backend with the given name
+func NewRuleProvisioner(backend string) (poltypes.RuleProvisioner, error) {
+  switch backend {
+  case IptablesProvisionerName:
+    return iptables.NewIptablesProvisioner(), nil
+  case NftablesProvisionerName:
+    return nftables.NewNftablesProvisioner(), nil
+  }
+  return nil, errors.New("unsupported rule provisioner:" + backend + ", supported values are: " + IptablesProvisionerName + ", " + NftablesProvisionerName)
+}
+
+func (netpolController *NetPolControl) Run(threadiness int) error {
   go netpolController.PolicyController.Run(*netpolController.StopChan)
   go netpolController.PodController.Run(*netpolController.StopChan)
+  go netpolController.DanmEpController.Run(*netpolController.StopChan)
+  go netpolController.NamespaceController.Run(*netpolController.StopChan)
+  cacheSyncs := []cache.InformerSynced{netpolController.PolicyController.HasSynced, netpolController.PodController.HasSynced,
+    netpolController.DanmEpController.HasSynced, netpolController.NamespaceController.HasSynced}
+  if netpolController.ClusterPolicyController != nil {
+    go netpolController.ClusterPolicyController.Run(*netpolController.StopChan)
+    cacheSyncs = append(cacheSyncs, netpolController.ClusterPolicyController.HasSynced)
+  }
+  if netpolController.NetworkPolicyController != nil {
+    go netpolController.NetworkPolicyController.Run(*netpolController.StopChan)
+    cacheSyncs = append(cacheSyncs, netpolController.NetworkPolicyController.HasSynced)
+  }
+  log.Println("INFO: waiting for DANM Network Policy Controller to synchronize cache")
+  if ok := cache.WaitForCacheSync(*netpolController.StopChan, cacheSyncs...); !ok {
+    return errors.New("synching DANM Network Policy Controller's cache failed")
+  }
+  for i := 0; i < threadiness; i++ {
+    go wait.Until(netpolController.runWorker, time.Second, *netpolController.StopChan)
+  }
+  //Policy statuses are updated by one thread, so the Policer does not race with itself
+  go wait.Until(netpolController.runStatusWorker, time.Second, *netpolController.StopChan)
+  //Policies might have changed while the Policer was down, and rules provisioned by its previous instance are not remembered, so all local Pods are resynced right away
+  if netpolController.ResyncInterval > 0 {
+    go wait.Until(netpolController.resyncLocalPods, netpolController.ResyncInterval, *netpolController.StopChan)
+  } else {
+    go netpolController.resyncLocalPods()
+  }
+  log.Println("INFO: Successfully started DANM Network Policy Controller's event handler threads")
+  return nil
 }
 
 func (netpolController *NetPolControl) WatchErrorHandler(r *cache.Reflector, err error) {
@@ -96,66 +232,570 @@ func (netpolController *NetPolControl) WatchErrorHandler(r *cache.Reflector, err
 func (netpolCtrl *NetPolControl) createPolicyController() {
   netpolInformerFactory := polinformers.NewSharedInformerFactory(netpolCtrl.PolicyClient, time.Second*30)
   polController := netpolInformerFactory.Netpol().V1().DanmNetworkPolicies().Informer()
+  polController.AddIndexers(polset.Indexers)
   polController.AddEventHandler(cache.ResourceEventHandlerFuncs{
-      AddFunc: AddNetPol,
-      UpdateFunc: UpdateNetPol,
-      DeleteFunc: DeleteNetPol,
+      AddFunc: netpolCtrl.AddNetPol,
+      UpdateFunc: netpolCtrl.UpdateNetPol,
+      DeleteFunc: netpolCtrl.DeleteNetPol,
   })
   polController.SetWatchErrorHandler(netpolCtrl.WatchErrorHandler)
   netpolCtrl.PolicyController = polController
 }
 
-func (netpolCtrl *NetPolControl) createPodController(cfg *rest.Config) {
-  kubeClient, _ := kubernetes.NewForConfig(cfg)
-  kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
-  podController := kubeInformerFactory.Core().V1().Pods().Informer()
+func (netpolCtrl *NetPolControl) createClusterPolicyController() {
+  netpolInformerFactory := polinformers.NewSharedInformerFactory(netpolCtrl.PolicyClient, time.Second*30)
+  clusterPolInformer := netpolInformerFactory.Netpol().V1().ClusterDanmNetworkPolicies()
+  clusterPolController := clusterPolInformer.Informer()
+  clusterPolController.AddEventHandler(cache.ResourceEventHandlerFuncs{
+      AddFunc: netpolCtrl.AddClusterNetPol,
+      UpdateFunc: netpolCtrl.UpdateClusterNetPol,
+      DeleteFunc: netpolCtrl.DeleteClusterNetPol,
+  })
+  clusterPolController.SetWatchErrorHandler(netpolCtrl.WatchErrorHandler)
+  netpolCtrl.ClusterPolicyController = clusterPolController
+  netpolCtrl.ClusterPolicyLister = clusterPolInformer.Lister()
+}
+
+func (netpolCtrl *NetPolControl) createPodController() {
+  //Only the Pods of the local node are isolated, so there is no point in watching the Pods of the whole cluster on every node
+  podInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(netpolCtrl.KubeClient, time.Second*30,
+    kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+      options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", ControllerNode).String()
+    }))
+  podInformer := podInformerFactory.Core().V1().Pods()
+  podController := podInformer.Informer()
   podController.AddEventHandler(cache.ResourceEventHandlerFuncs{
       AddFunc: netpolCtrl.AddPod,
       UpdateFunc: netpolCtrl.UpdatePod,
+      DeleteFunc: netpolCtrl.DeletePod,
   })
   podController.SetWatchErrorHandler(netpolCtrl.WatchErrorHandler)
   netpolCtrl.PodController = podController
+  netpolCtrl.PodLister = podInformer.Lister()
+  //Namespace labels are needed to evaluate the namespaceSelectors of the peers
+  kubeInformerFactory := kubeinformers.NewSharedInformerFactory(netpolCtrl.KubeClient, time.Second*30)
+  namespaceInformer := kubeInformerFactory.Core().V1().Namespaces()
+  namespaceController := namespaceInformer.Informer()
+  namespaceController.AddEventHandler(cache.ResourceEventHandlerFuncs{
+      UpdateFunc: netpolCtrl.UpdateNamespace,
+  })
+  namespaceController.SetWatchErrorHandler(netpolCtrl.WatchErrorHandler)
+  netpolCtrl.NamespaceController = namespaceController
+  netpolCtrl.NamespaceLister = namespaceInformer.Lister()
+}
+
+func (netpolCtrl *NetPolControl) createNetworkPolicyController() {
+  dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(netpolCtrl.DynamicClient, time.Second*30)
+  netPolController := dynamicInformerFactory.ForResource(NetworkPolicyResource).Informer()
+  netPolController.AddEventHandler(cache.ResourceEventHandlerFuncs{
+      AddFunc: netpolCtrl.AddNetworkPolicy,
+      UpdateFunc: netpolCtrl.UpdateNetworkPolicy,
+      DeleteFunc: netpolCtrl.DeleteNetworkPolicy,
+  })
+  netPolController.SetWatchErrorHandler(netpolCtrl.WatchErrorHandler)
+  netpolCtrl.NetworkPolicyController = netPolController
+  netpolCtrl.NetworkPolicyLister = dynamiclister.New(netPolController.GetIndexer(), NetworkPolicyResource)
+}
+
+func (netpolCtrl *NetPolControl) createDanmEpController() {
+  danmInformerFactory := danminformers.NewSharedInformerFactory(netpolCtrl.DanmClient, time.Second*30)
+  depController := danmInformerFactory.Danm().V1().DanmEps().Informer()
+  depController.AddIndexers(depset.Indexers)
+  depController.AddEventHandler(cache.ResourceEventHandlerFuncs{
+      AddFunc: netpolCtrl.AddDanmEp,
+      UpdateFunc: netpolCtrl.UpdateDanmEp,
+      DeleteFunc: netpolCtrl.DeleteDanmEp,
+  })
+  depController.SetWatchErrorHandler(netpolCtrl.WatchErrorHandler)
+  netpolCtrl.DanmEpController = depController
 }
 
-//TODO: implement event handlers
-func AddNetPol(netpol interface{}) {}
-func UpdateNetPol(oldNetpol, newNetpol interface{}) {}
-func DeleteNetPol(netpol interface{}) {}
+func (netpolCtrl *NetPolControl) AddNetPol(netpol interface{}) {
+  netpolObj := netpol.(*polv1.DanmNetworkPolicy)
+  netpolCtrl.enqueuePolicyStatus(netpolObj)
+  netpolCtrl.reconcileSelectedPods(netpolObj.ObjectMeta.Namespace, *netpolObj)
+}
 
-func (netpolCtrl *NetPolControl) AddPod(pod interface{}) {
-  podObj := pod.(*corev1.Pod)
-  if podObj.Spec.NodeName != ControllerNode {
+func (netpolCtrl *NetPolControl) UpdateNetPol(oldNetpol, newNetpol interface{}) {
+  oldNetpolObj := oldNetpol.(*polv1.DanmNetworkPolicy)
+  newNetpolObj := newNetpol.(*polv1.DanmNetworkPolicy)
+  //Periodic resyncs, and status updates are not real changes, the Pods are already in the desired state
+  if oldNetpolObj.ObjectMeta.Generation == newNetpolObj.ObjectMeta.Generation {
     return
   }
-  policySet  := polset.NewPolicySet(netpolCtrl.PolicyClient, podObj.ObjectMeta.Namespace)
-  applicablePols := policySet.FilterApplicablePolicies(podObj)
-  //By K8s documentation a Pod is only considered isolated if there is any network policy selecting it
-  if len(applicablePols) == 0 {
+  netpolCtrl.enqueuePolicyStatus(newNetpolObj)
+  //Pods only selected by the old version might lose their isolation, while Pods selected by the new version might just gain it
+  netpolCtrl.reconcileSelectedPods(newNetpolObj.ObjectMeta.Namespace, *oldNetpolObj, *newNetpolObj)
+}
+
+func (netpolCtrl *NetPolControl) DeleteNetPol(netpol interface{}) {
+  netpolObj, ok := netpol.(*polv1.DanmNetworkPolicy)
+  if !ok {
+    tombstone, ok := netpol.(cache.DeletedFinalStateUnknown)
+    if !ok {
+      return
+    }
+    netpolObj, ok = tombstone.Obj.(*polv1.DanmNetworkPolicy)
+    if !ok {
+      return
+    }
+  }
+  netpolCtrl.reconcileSelectedPods(netpolObj.ObjectMeta.Namespace, *netpolObj)
+}
+
+//reconcileSelectedPods recalculates, and re-provisions the rules of all the local Pods selected by any of the changed policies
+//The policies themselves are only used to identify the affected Pods, the rules are always calculated from the current state of the API
+func (netpolCtrl *NetPolControl) reconcileSelectedPods(namespace string, changedPols ...polv1.DanmNetworkPolicy) {
+  for _, pod := range polset.FilterSelectedPods(changedPols, netpolCtrl.listLocalPods(namespace)) {
+    netpolCtrl.enqueuePod(pod)
+  }
+}
+
+func (netpolCtrl *NetPolControl) AddClusterNetPol(netpol interface{}) {
+  netpolObj := netpol.(*polv1.ClusterDanmNetworkPolicy)
+  netpolCtrl.reconcileClusterSelectedPods(*netpolObj)
+}
+
+func (netpolCtrl *NetPolControl) UpdateClusterNetPol(oldNetpol, newNetpol interface{}) {
+  oldNetpolObj := oldNetpol.(*polv1.ClusterDanmNetworkPolicy)
+  newNetpolObj := newNetpol.(*polv1.ClusterDanmNetworkPolicy)
+  //Metadata only changes don't alter the rules, so Pods of every namespace shouldn't be reconciled because of them
+  if oldNetpolObj.ObjectMeta.Generation == newNetpolObj.ObjectMeta.Generation {
     return
   }
-  var depSet *poltypes.DanmEpSet
-  for i := 0; i < MaxRetryCount; i++ {
-    depSet = depset.NewDanmEpSet(netpolCtrl.DanmClient, podObj)
-    //CNI might just be creating the DanmEps for the Pod
-    //To be on the safe side we need to retry a couple of times before we can decide we have an error
-    if len(depSet.PodEps) != 0 {break}
-    time.Sleep(LongRetryInterval * time.Millisecond)
+  netpolCtrl.reconcileClusterSelectedPods(*oldNetpolObj, *newNetpolObj)
+}
+
+func (netpolCtrl *NetPolControl) DeleteClusterNetPol(netpol interface{}) {
+  netpolObj, ok := netpol.(*polv1.ClusterDanmNetworkPolicy)
+  if !ok {
+    tombstone, ok := netpol.(cache.DeletedFinalStateUnknown)
+    if !ok {
+      return
+    }
+    netpolObj, ok = tombstone.Obj.(*polv1.ClusterDanmNetworkPolicy)
+    if !ok {
+      return
+    }
   }
-  if len(depSet.PodEps) == 0 {
-    log.Println("ERROR: DanmNetworkPolicy provisioning is impossible for Pod:" + podObj.ObjectMeta.Name + " in namespace:" +
-      podObj.ObjectMeta.Name + " becuase its networking is not managed by DANM!")
+  netpolCtrl.reconcileClusterSelectedPods(*netpolObj)
+}
+
+//reconcileClusterSelectedPods re-provisions the local Pods selected by any of the changed cluster-wide policies in any of the namespaces they select
+func (netpolCtrl *NetPolControl) reconcileClusterSelectedPods(changedPols ...polv1.ClusterDanmNetworkPolicy) {
+  namespaces, err := netpolCtrl.NamespaceLister.List(labels.Everything())
+  if err != nil {
+    log.Println("ERROR: can't list namespaces because:" + err.Error())
     return
   }
-  //Kubernetes doesn't remember the netns of the Pod, but we do. We need to read it from one of the DanmEps belonging to the Pod
-  netRuleSet := netruleset.NewNetRuleSet(applicablePols, depSet)
-  //TODOD: make this configurable once we have multiple executors to choose from
-  ruleProvisioner := iptables.NewIptablesProvisioner()
-  go ruleProvisioner.AddRulesToNewPod(netRuleSet, podObj)
+  for _, namespace := range namespaces {
+    netPols := polset.FilterClusterPolicies(changedPols, namespace.ObjectMeta.Name, namespace.ObjectMeta.Labels)
+    if len(netPols) > 0 {
+      netpolCtrl.reconcileSelectedPods(namespace.ObjectMeta.Name, netPols...)
+    }
+  }
+}
+
+func (netpolCtrl *NetPolControl) AddNetworkPolicy(netpol interface{}) {
+  netpolObj := netpol.(*unstructured.Unstructured)
+  netpolCtrl.reconcileMirroredPods(*netpolObj)
+}
+
+func (netpolCtrl *NetPolControl) UpdateNetworkPolicy(oldNetpol, newNetpol interface{}) {
+  oldNetpolObj := oldNetpol.(*unstructured.Unstructured)
+  newNetpolObj := newNetpol.(*unstructured.Unstructured)
+  if oldNetpolObj.GetGeneration() == newNetpolObj.GetGeneration() {
+    return
+  }
+  netpolCtrl.reconcileMirroredPods(*oldNetpolObj, *newNetpolObj)
+}
+
+func (netpolCtrl *NetPolControl) DeleteNetworkPolicy(netpol interface{}) {
+  netpolObj, ok := netpol.(*unstructured.Unstructured)
+  if !ok {
+    tombstone, ok := netpol.(cache.DeletedFinalStateUnknown)
+    if !ok {
+      return
+    }
+    netpolObj, ok = tombstone.Obj.(*unstructured.Unstructured)
+    if !ok {
+      return
+    }
+  }
+  netpolCtrl.reconcileMirroredPods(*netpolObj)
+}
+
+//reconcileMirroredPods re-provisions the local Pods selected by any of the changed NetworkPolicies, if their namespace opted in to mirroring
+func (netpolCtrl *NetPolControl) reconcileMirroredPods(changedPols ...unstructured.Unstructured) {
+  namespace := changedPols[0].GetNamespace()
+  namespaceObj, err := netpolCtrl.NamespaceLister.Get(namespace)
+  if err != nil || !netpolCtrl.isMirroredNamespace(namespaceObj) {
+    return
+  }
+  netpolCtrl.reconcileSelectedPods(namespace, polset.ConvertNetworkPolicies(changedPols)...)
+}
+
+//isMirroredNamespace tells whether the upstream NetworkPolicies of the namespace are to be enforced by the Policer
+//Namespaces need to explicitly opt in, as the primary CNI might already enforce the same NetworkPolicies on the first interface
+func (netpolCtrl *NetPolControl) isMirroredNamespace(namespace *corev1.Namespace) bool {
+  return netpolCtrl.NetworkPolicyLister != nil && namespace.ObjectMeta.Annotations[poltypes.MirrorNetworkPoliciesAnnotation] == "true"
+}
+
+//listMirroredNetworkPolicies returns the upstream NetworkPolicies of the namespace, or nothing when the namespace did not opt in to mirroring
+func (netpolCtrl *NetPolControl) listMirroredNetworkPolicies(namespace string) []unstructured.Unstructured {
+  namespaceObj, err := netpolCtrl.NamespaceLister.Get(namespace)
+  if err != nil || !netpolCtrl.isMirroredNamespace(namespaceObj) {
+    return make([]unstructured.Unstructured, 0)
+  }
+  return netpolCtrl.listNetworkPolicies(namespace)
+}
+
+//listNetworkPolicies returns the upstream NetworkPolicies of the namespace, or nothing when mirroring is disabled
+func (netpolCtrl *NetPolControl) listNetworkPolicies(namespace string) []unstructured.Unstructured {
+  netPols := make([]unstructured.Unstructured, 0)
+  if netpolCtrl.NetworkPolicyLister == nil {
+    return netPols
+  }
+  netPolPtrs, err := netpolCtrl.NetworkPolicyLister.Namespace(namespace).List(labels.Everything())
+  if err != nil {
+    log.Println("ERROR: can't list NetworkPolicies in namespace:" + namespace + " because:" + err.Error())
+    return netPols
+  }
+  for _, netPol := range netPolPtrs {
+    netPols = append(netPols, *netPol)
+  }
+  return netPols
+}
+
+//listClusterPolicies returns all the cluster-wide policies, or nothing when their API is not installed
+func (netpolCtrl *NetPolControl) listClusterPolicies() []polv1.ClusterDanmNetworkPolicy {
+  clusterPols := make([]polv1.ClusterDanmNetworkPolicy, 0)
+  if netpolCtrl.ClusterPolicyLister == nil {
+    return clusterPols
+  }
+  clusterPolPtrs, err := netpolCtrl.ClusterPolicyLister.List(labels.Everything())
+  if err != nil {
+    log.Println("ERROR: can't list ClusterDanmNetworkPolicies because:" + err.Error())
+    return clusterPols
+  }
+  for _, clusterPol := range clusterPolPtrs {
+    clusterPols = append(clusterPols, *clusterPol)
+  }
+  return clusterPols
+}
+
+//newPolicySet returns the namespaced policies of the namespace, together with the cluster-wide policies selecting the namespace, and the mirrored NetworkPolicies of the namespace
+func (netpolCtrl *NetPolControl) newPolicySet(namespace string) *polset.PolicySet {
+  policySet := polset.NewPolicySet(netpolCtrl.PolicyController.GetIndexer(), namespace)
+  policySet.AddClusterPolicies(netpolCtrl.listClusterPolicies(), namespace, netpolCtrl.getNamespaceLabels(namespace))
+  policySet.AddNetworkPolicies(netpolCtrl.listMirroredNetworkPolicies(namespace))
+  return policySet
 }
+
+func (netpolCtrl *NetPolControl) AddDanmEp(dep interface{}) {
+  depObj := dep.(*danmv1.DanmEp)
+  netpolCtrl.enqueueOwnerPod(depObj)
+  //The initial state of all the peers is anyway taken into account when the Pods are first provisioned
+  if !netpolCtrl.DanmEpController.HasSynced() {
+    return
+  }
+  netpolCtrl.reconcilePeerPods(depObj)
+}
+
+//enqueueOwnerPod schedules the provisioning of the local Pod owning the DanmEp
+//Rules of a Pod can't be provisioned before its DanmEps are created, so this way the Pod doesn't have to wait for its next retry
+//Only local Pods are found in the cache, the DanmEps of other nodes are ignored
+func (netpolCtrl *NetPolControl) enqueueOwnerPod(dep *danmv1.DanmEp) {
+  pod, err := netpolCtrl.PodLister.Pods(dep.ObjectMeta.Namespace).Get(dep.Spec.Pod)
+  if err != nil || pod.ObjectMeta.UID != dep.Spec.PodUID {
+    return
+  }
+  netpolCtrl.enqueuePod(pod)
+}
+
+func (netpolCtrl *NetPolControl) UpdateDanmEp(oldDep, newDep interface{}) {
+  oldDepObj := oldDep.(*danmv1.DanmEp)
+  newDepObj := newDep.(*danmv1.DanmEp)
+  //Only the addresses, the labels, the network and the interface of an endpoint are used in rule calculation
+  if oldDepObj.Spec.Iface.Address == newDepObj.Spec.Iface.Address &&
+     oldDepObj.Spec.Iface.AddressIPv6 == newDepObj.Spec.Iface.AddressIPv6 &&
+     oldDepObj.Spec.Iface.Name == newDepObj.Spec.Iface.Name &&
+     oldDepObj.Spec.NetworkName == newDepObj.Spec.NetworkName &&
+     oldDepObj.Spec.NetworkType == newDepObj.Spec.NetworkType &&
+     oldDepObj.Spec.ApiType == newDepObj.Spec.ApiType &&
+     labels.Equals(oldDepObj.ObjectMeta.Labels, newDepObj.ObjectMeta.Labels) {
+    return
+  }
+  //The network and the interface of its own endpoints decide which interfaces of the owner Pod are targeted
+  netpolCtrl.enqueueOwnerPod(newDepObj)
+  //Pods which only selected the old version of the peer have to be updated just as well as the ones selecting the new version
+  netpolCtrl.reconcilePeerPods(oldDepObj, newDepObj)
+}
+
+func (netpolCtrl *NetPolControl) DeleteDanmEp(dep interface{}) {
+  depObj, ok := dep.(*danmv1.DanmEp)
+  if !ok {
+    tombstone, ok := dep.(cache.DeletedFinalStateUnknown)
+    if !ok {
+      return
+    }
+    depObj, ok = tombstone.Obj.(*danmv1.DanmEp)
+    if !ok {
+      return
+    }
+  }
+  netpolCtrl.peerPods.Delete(depObj.Spec.PodUID)
+  netpolCtrl.reconcilePeerPods(depObj)
+}
+
+//reconcilePeerPods recalculates, and re-provisions the rules of all the isolated local Pods having policies which select any of the changed DanmEps as a peer
+//Peers can be selected from any namespace via namespaceSelectors, so local Pods of all namespaces are checked
+func (netpolCtrl *NetPolControl) reconcilePeerPods(changedDeps ...*danmv1.DanmEp) {
+  localPods := netpolCtrl.listLocalPods(metav1.NamespaceAll)
+  if len(localPods) == 0 {
+    return
+  }
+  namespaceLabels := netpolCtrl.getNamespaceLabels(changedDeps[0].ObjectMeta.Namespace)
+  policySets := make(map[string]*polset.PolicySet)
+  for _, pod := range localPods {
+    policySet, ok := policySets[pod.ObjectMeta.Namespace]
+    if !ok {
+      policySet = netpolCtrl.newPolicySet(pod.ObjectMeta.Namespace)
+      policySets[pod.ObjectMeta.Namespace] = policySet
+    }
+    applicablePols := policySet.FilterApplicablePolicies(pod)
+    for _, dep := range changedDeps {
+      if netruleset.IsDanmEpSelected(applicablePols, *dep, namespaceLabels) {
+        netpolCtrl.enqueuePod(pod)
+        break
+      }
+    }
+  }
+}
+
+func (netpolCtrl *NetPolControl) getNamespaceLabels(namespace string) map[string]string {
+  namespaceObj, err := netpolCtrl.NamespaceLister.Get(namespace)
+  if err != nil {
+    log.Println("WARNING: can't get labels of namespace:" + namespace + " because:" + err.Error())
+    return nil
+  }
+  return namespaceObj.ObjectMeta.Labels
+}
+
+//UpdateNamespace re-provisions all the isolated local Pods when the labels of a namespace change
+//The new labels might make the namespace selected, or not selected anymore by the namespaceSelector of any peer, or of any cluster-wide policy
+//The Pods selected by the NetworkPolicies of the namespace are re-provisioned when the namespace opts in, or out of mirroring
+func (netpolCtrl *NetPolControl) UpdateNamespace(oldNamespace, newNamespace interface{}) {
+  oldNamespaceObj := oldNamespace.(*corev1.Namespace)
+  newNamespaceObj := newNamespace.(*corev1.Namespace)
+  if netpolCtrl.isMirroredNamespace(oldNamespaceObj) != netpolCtrl.isMirroredNamespace(newNamespaceObj) {
+    netpolCtrl.reconcileSelectedPods(newNamespaceObj.ObjectMeta.Name,
+      polset.ConvertNetworkPolicies(netpolCtrl.listNetworkPolicies(newNamespaceObj.ObjectMeta.Name))...)
+  }
+  if labels.Equals(oldNamespaceObj.ObjectMeta.Labels, newNamespaceObj.ObjectMeta.Labels) {
+    return
+  }
+  netpolCtrl.isolatedPods.Range(func(key, value interface{}) bool {
+    netpolCtrl.Workqueue.Add(key)
+    return true
+  })
+  //Pods not isolated so far might have just become selected by a cluster-wide policy
+  netpolCtrl.reconcileSelectedPods(newNamespaceObj.ObjectMeta.Name,
+    polset.FilterClusterPolicies(netpolCtrl.listClusterPolicies(), newNamespaceObj.ObjectMeta.Name, newNamespaceObj.ObjectMeta.Labels)...)
+}
+
+//getPod returns the peer Pods used to resolve the named ports of egress rules
+//Local Pods come from the informer, while remote Pods are only fetched from the API the first time they are needed
+//Container ports of a Pod can't be changed, so the cached Pods are keyed by their UID, and are only invalidated when their DanmEps are deleted
+//A Pod recreated with the same name gets a new UID, so it is never resolved from the entry of its predecessor
+func (netpolCtrl *NetPolControl) getPod(namespace, name string, uid types.UID) (*corev1.Pod, error) {
+  if pod, err := netpolCtrl.PodLister.Pods(namespace).Get(name); err == nil && pod.ObjectMeta.UID == uid {
+    return pod, nil
+  }
+  if pod, ok := netpolCtrl.peerPods.Load(uid); ok {
+    return pod.(*corev1.Pod), nil
+  }
+  pod, err := netpolCtrl.KubeClient.CoreV1().Pods(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+  if err != nil {
+    return nil, err
+  }
+  if pod.ObjectMeta.UID != uid {
+    return nil, errors.New("Pod:" + name + " in namespace:" + namespace + " was recreated since its DanmEp was created")
+  }
+  //Only the container ports are kept, so the cache does not hold the whole Pod of every remote peer
+  portPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: pod.ObjectMeta.Name, Namespace: pod.ObjectMeta.Namespace, UID: pod.ObjectMeta.UID}}
+  for _, container := range pod.Spec.Containers {
+    portPod.Spec.Containers = append(portPod.Spec.Containers, corev1.Container{Name: container.Name, Ports: container.Ports})
+  }
+  netpolCtrl.peerPods.Store(uid, portPod)
+  return portPod, nil
+}
+
+func (netpolCtrl *NetPolControl) listLocalPods(namespace string) []*corev1.Pod {
+  pods, err := netpolCtrl.PodLister.Pods(namespace).List(labels.Everything())
+  if err != nil {
+    log.Println("ERROR: can't list Pods in namespace:" + namespace + " because:" + err.Error())
+    return make([]*corev1.Pod, 0)
+  }
+  return pods
+}
+
+//resyncLocalPods schedules all the local Pods for comparing the rules found in their netns with the ones calculated from their policies
+//Pods whose rules drifted from their policies are re-provisioned, while Pods not selected by any policy are cleaned of the rules left behind
+func (netpolCtrl *NetPolControl) resyncLocalPods() {
+  pods := netpolCtrl.listLocalPods(metav1.NamespaceAll)
+  log.Println("INFO: Resyncing the rules of " + strconv.Itoa(len(pods)) + " local Pods")
+  for _, pod := range pods {
+    key, err := cache.MetaNamespaceKeyFunc(pod)
+    if err != nil {
+      log.Println("WARNING: Could not schedule Pod for rule resync because:" + err.Error())
+      continue
+    }
+    netpolCtrl.resyncPods.Store(key, true)
+    netpolCtrl.Workqueue.Add(key)
+  }
+}
+
+func (netpolCtrl *NetPolControl) AddPod(pod interface{}) {
+  netpolCtrl.enqueuePod(pod.(*corev1.Pod))
+}
+
 func (netpolCtrl *NetPolControl) UpdatePod(oldPod, newPod interface{}) {
   oldPodObj := oldPod.(*corev1.Pod)
   newPodObj := newPod.(*corev1.Pod)
-  if oldPodObj.Spec.NodeName != newPodObj.Spec.NodeName {
+  //Pods can become selected, or can stop being selected by policies when their labels change
+  if !labels.Equals(oldPodObj.ObjectMeta.Labels, newPodObj.ObjectMeta.Labels) {
     netpolCtrl.AddPod(newPod)
   }
 }
+
+func (netpolCtrl *NetPolControl) DeletePod(pod interface{}) {
+  podObj, ok := pod.(*corev1.Pod)
+  if !ok {
+    tombstone, ok := pod.(cache.DeletedFinalStateUnknown)
+    if !ok {
+      return
+    }
+    podObj, ok = tombstone.Obj.(*corev1.Pod)
+    if !ok {
+      return
+    }
+  }
+  //Rules are gone together with the netns, but we still need to forget about the Pod
+  netpolCtrl.AddPod(podObj)
+}
+
+func (netpolCtrl *NetPolControl) enqueuePod(pod *corev1.Pod) {
+  key, err := cache.MetaNamespaceKeyFunc(pod)
+  if err != nil {
+    log.Println("WARNING: Could not schedule Pod for DanmNetworkPolicy provisioning because:" + err.Error())
+    return
+  }
+  netpolCtrl.Workqueue.Add(key)
+}
+
+func (netpolCtrl *NetPolControl) runWorker() {
+  for netpolCtrl.processNextWorkItem() {}
+}
+
+func (netpolCtrl *NetPolControl) processNextWorkItem() bool {
+  obj, shutdown := netpolCtrl.Workqueue.Get()
+  if shutdown {
+    return false
+  }
+  defer netpolCtrl.Workqueue.Done(obj)
+  key, ok := obj.(string)
+  if !ok {
+    netpolCtrl.Workqueue.Forget(obj)
+    runtime.HandleError(fmt.Errorf("WARNING: Cannot decode work item from queue because instead string type we got %#v", obj))
+    return true
+  }
+  err := netpolCtrl.handleKey(key)
+  if err == nil {
+    netpolCtrl.Workqueue.Forget(obj)
+    return true
+  }
+  if netpolCtrl.Workqueue.NumRequeues(obj) < MaxRequeueCount {
+    log.Println("INFO: DanmNetworkPolicy provisioning for Pod:" + key + " failed with error:" + err.Error() + ", retrying later")
+    netpolCtrl.Workqueue.AddRateLimited(obj)
+    return true
+  }
+  netpolCtrl.Workqueue.Forget(obj)
+  log.Println("ERROR: DanmNetworkPolicy provisioning for Pod:" + key + " failed with error:" + err.Error() + ", giving up!")
+  return true
+}
+
+//handleKey brings the rules of a local Pod in line with the policies currently selecting it, and reports the outcome in the status of the policies
+func (netpolCtrl *NetPolControl) handleKey(key string) error {
+  applicablePols, err := netpolCtrl.provisionPod(key)
+  netpolCtrl.recordProvisioningResult(key, applicablePols, err)
+  //Failed resyncs are retried as resyncs, as a normal provisioning would not clean-up the Pods not remembered to be isolated
+  if err == nil {
+    netpolCtrl.resyncPods.Delete(key)
+  }
+  return err
+}
+
+//provisionPod returns the policies selecting the Pod, together with the outcome of provisioning their rules
+//Rules are provisioned when the Pod is selected by any policy, and all isolation is removed when it was isolated before, but it isn't anymore
+//During a resync the rules are only provisioned, or removed when the ones found in the netns of the Pod differ from the ones calculated from its policies
+func (netpolCtrl *NetPolControl) provisionPod(key string) ([]polv1.DanmNetworkPolicy, error) {
+  namespace, name, err := cache.SplitMetaNamespaceKey(key)
+  if err != nil {
+    log.Println("WARNING: Dropping work item because its key:" + key + " could not be broken up into API object identifiers due to error:" + err.Error())
+    return nil, nil
+  }
+  pod, err := netpolCtrl.PodLister.Pods(namespace).Get(name)
+  if apierrors.IsNotFound(err) {
+    netpolCtrl.isolatedPods.Delete(key)
+    netpolCtrl.resyncPods.Delete(key)
+    return nil, nil
+  } else if err != nil {
+    return nil, err
+  }
+  policySet  := netpolCtrl.newPolicySet(namespace)
+  applicablePols := policySet.FilterApplicablePolicies(pod)
+  _, wasIsolated := netpolCtrl.isolatedPods.Load(key)
+  _, isResync := netpolCtrl.resyncPods.Load(key)
+  //By K8s documentation a Pod is only considered isolated if there is any network policy selecting it
+  if len(applicablePols) == 0 && !wasIsolated && !isResync {
+    return nil, nil
+  }
+  depSet := depset.NewDanmEpSet(netpolCtrl.DanmEpController.GetIndexer(), netpolCtrl.NamespaceLister, pod)
+  //CNI might just be creating the DanmEps for the Pod
+  //To be on the safe side we need to retry a couple of times before we can decide we have an error
+  if len(depSet.PodEps) == 0 {
+    //Pods not managed by DANM can't have Policer provisioned rules either, so there is nothing to resync
+    if len(applicablePols) == 0 && !wasIsolated {
+      return nil, nil
+    }
+    return applicablePols, errors.New("DanmNetworkPolicy provisioning is impossible because the Pod's networking is not managed by DANM")
+  }
+  //Kubernetes doesn't remember the netns of the Pod, but we do. We need to read it from one of the DanmEps belonging to the Pod
+  netRuleSet := netruleset.NewNetRuleSet(applicablePols, depSet, pod, netpolCtrl.getPod)
+  if isResync {
+    isProvisioned, err := netpolCtrl.RuleProvisioner.IsRuleSetProvisioned(netRuleSet, pod)
+    if err != nil {
+      return applicablePols, errors.New("rules of the Pod could not be read because:" + err.Error())
+    }
+    if isProvisioned {
+      if len(applicablePols) > 0 {
+        netpolCtrl.isolatedPods.Store(key, true)
+      } else {
+        netpolCtrl.isolatedPods.Delete(key)
+      }
+      return applicablePols, nil
+    }
+    log.Println("INFO: rules of Pod:" + key + " drifted from the policies selecting it, re-provisioning them")
+  }
+  if len(applicablePols) == 0 {
+    err = netpolCtrl.RuleProvisioner.RemoveRulesFromPod(netRuleSet, pod)
+    if err == nil {
+      netpolCtrl.isolatedPods.Delete(key)
+    }
+    return nil, err
+  }
+  //Pod is remembered even before provisioning, so partially provisioned rules can be cleaned-up later too
+  netpolCtrl.isolatedPods.Store(key, true)
+  return applicablePols, netpolCtrl.RuleProvisioner.AddRulesToPod(netRuleSet, pod)
+}
\ No newline at end of file
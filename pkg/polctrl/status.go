@@ -0,0 +1,234 @@
+package polctrl
+
+import (
+  "context"
+  "fmt"
+  "log"
+  "sort"
+  "strings"
+  polv1 "github.com/nokia/danm-utils/crd/api/netpol/v1"
+  "github.com/nokia/danm-utils/pkg/validation"
+  corev1 "k8s.io/api/core/v1"
+  "k8s.io/apimachinery/pkg/api/equality"
+  apierrors "k8s.io/apimachinery/pkg/api/errors"
+  metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+  "k8s.io/apimachinery/pkg/types"
+  "k8s.io/apimachinery/pkg/util/runtime"
+  "k8s.io/apimachinery/pkg/util/validation/field"
+  "k8s.io/client-go/tools/cache"
+  "k8s.io/client-go/util/retry"
+)
+
+//provisioningResult is the outcome of the last rule provisioning into a local Pod
+type provisioningResult struct {
+  //PolicyKeys maps the UIDs of the policies selecting the Pod to their namespace/name keys
+  PolicyKeys map[types.UID]string
+  Err        string
+}
+
+//recordProvisioningResult remembers the outcome of the rule provisioning into a local Pod, and schedules the status update of the affected policies
+//Policies selecting the Pod before are updated too, as they might not select it anymore
+//Only DanmNetworkPolicies have a status, so the policies converted from ClusterDanmNetworkPolicies, or NetworkPolicies are not recorded
+func (netpolCtrl *NetPolControl) recordProvisioningResult(podKey string, applicablePols []polv1.DanmNetworkPolicy, err error) {
+  changedPolKeys := make(map[string]bool)
+  if oldResult, ok := netpolCtrl.provisioningResults.Load(podKey); ok {
+    for _, polKey := range oldResult.(provisioningResult).PolicyKeys {
+      changedPolKeys[polKey] = true
+    }
+  }
+  if len(applicablePols) == 0 {
+    netpolCtrl.provisioningResults.Delete(podKey)
+  } else {
+    result := provisioningResult{PolicyKeys: make(map[types.UID]string)}
+    if err != nil {
+      result.Err = err.Error()
+    }
+    for _, policy := range applicablePols {
+      polKey := policy.ObjectMeta.Namespace + "/" + policy.ObjectMeta.Name
+      if !netpolCtrl.isDanmNetworkPolicy(polKey, policy.ObjectMeta.UID) {
+        continue
+      }
+      result.PolicyKeys[policy.ObjectMeta.UID] = polKey
+      changedPolKeys[polKey] = true
+    }
+    netpolCtrl.provisioningResults.Store(podKey, result)
+  }
+  for polKey := range changedPolKeys {
+    netpolCtrl.StatusWorkqueue.Add(polKey)
+  }
+}
+
+//isDanmNetworkPolicy tells whether an applicable policy is a DanmNetworkPolicy known by the informer
+//Converted policies either have a key not known by the informer, or the UID of another kind of policy
+func (netpolCtrl *NetPolControl) isDanmNetworkPolicy(polKey string, uid types.UID) bool {
+  obj, exists, err := netpolCtrl.PolicyController.GetIndexer().GetByKey(polKey)
+  if err != nil || !exists {
+    return false
+  }
+  policy, ok := obj.(*polv1.DanmNetworkPolicy)
+  return ok && policy.ObjectMeta.UID == uid
+}
+
+func (netpolCtrl *NetPolControl) enqueuePolicyStatus(policy *polv1.DanmNetworkPolicy) {
+  key, err := cache.MetaNamespaceKeyFunc(policy)
+  if err != nil {
+    log.Println("WARNING: Could not schedule status update of DanmNetworkPolicy because:" + err.Error())
+    return
+  }
+  netpolCtrl.StatusWorkqueue.Add(key)
+}
+
+func (netpolCtrl *NetPolControl) runStatusWorker() {
+  for netpolCtrl.processNextStatusItem() {}
+}
+
+func (netpolCtrl *NetPolControl) processNextStatusItem() bool {
+  obj, shutdown := netpolCtrl.StatusWorkqueue.Get()
+  if shutdown {
+    return false
+  }
+  defer netpolCtrl.StatusWorkqueue.Done(obj)
+  key, ok := obj.(string)
+  if !ok {
+    netpolCtrl.StatusWorkqueue.Forget(obj)
+    runtime.HandleError(fmt.Errorf("WARNING: Cannot decode status work item from queue because instead string type we got %#v", obj))
+    return true
+  }
+  err := netpolCtrl.updatePolicyStatus(key)
+  if err == nil {
+    netpolCtrl.StatusWorkqueue.Forget(obj)
+    return true
+  }
+  if netpolCtrl.StatusWorkqueue.NumRequeues(obj) < MaxRequeueCount {
+    log.Println("INFO: status update of DanmNetworkPolicy:" + key + " failed with error:" + err.Error() + ", retrying later")
+    netpolCtrl.StatusWorkqueue.AddRateLimited(obj)
+    return true
+  }
+  netpolCtrl.StatusWorkqueue.Forget(obj)
+  log.Println("ERROR: status update of DanmNetworkPolicy:" + key + " failed with error:" + err.Error() + ", giving up!")
+  return true
+}
+
+//updatePolicyStatus refreshes the conditions of the policy, and the provisioning results of the local node in its status
+//The status of the policy is shared by the Policers of all the nodes, so it is always re-read from the API before updating it
+func (netpolCtrl *NetPolControl) updatePolicyStatus(key string) error {
+  namespace, name, err := cache.SplitMetaNamespaceKey(key)
+  if err != nil {
+    log.Println("WARNING: Dropping status work item because its key:" + key + " could not be broken up into API object identifiers due to error:" + err.Error())
+    return nil
+  }
+  return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+    policy, err := netpolCtrl.PolicyClient.NetpolV1().DanmNetworkPolicies(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+    if apierrors.IsNotFound(err) {
+      return nil
+    } else if err != nil {
+      return err
+    }
+    newStatus := netpolCtrl.calculatePolicyStatus(policy)
+    if equality.Semantic.DeepEqual(policy.Status, newStatus) {
+      return nil
+    }
+    policy.Status = newStatus
+    _, err = netpolCtrl.PolicyClient.NetpolV1().DanmNetworkPolicies(namespace).UpdateStatus(context.TODO(), policy, metav1.UpdateOptions{})
+    return err
+  })
+}
+
+func (netpolCtrl *NetPolControl) calculatePolicyStatus(policy *polv1.DanmNetworkPolicy) polv1.NetPolStatus {
+  status := *policy.Status.DeepCopy()
+  status.ObservedGeneration = policy.ObjectMeta.Generation
+  parsedCondition := polv1.NetPolCondition{Type: polv1.NetPolParsed, Status: corev1.ConditionTrue, Reason: polv1.ReasonValid}
+  if errs := validation.ValidateNetPolSpec(policy.Spec, field.NewPath("spec")); len(errs) > 0 {
+    parsedCondition = polv1.NetPolCondition{Type: polv1.NetPolParsed, Status: corev1.ConditionFalse, Reason: polv1.ReasonInvalid, Message: errs.ToAggregate().Error()}
+  }
+  status.Conditions = setCondition(status.Conditions, parsedCondition)
+  status.Nodes = setNodeStatus(status.Nodes, netpolCtrl.calculateNodeStatus(policy.ObjectMeta.UID))
+  status.SelectedPods = 0
+  failedNodes := make([]string, 0)
+  for _, nodeStatus := range status.Nodes {
+    status.SelectedPods += nodeStatus.SelectedPods
+    if nodeStatus.FailedPods > 0 {
+      failedNodes = append(failedNodes, nodeStatus.NodeName)
+    }
+  }
+  provisionedCondition := polv1.NetPolCondition{Type: polv1.NetPolProvisioned, Status: corev1.ConditionTrue, Reason: polv1.ReasonProvisioned}
+  if len(failedNodes) > 0 {
+    provisionedCondition = polv1.NetPolCondition{Type: polv1.NetPolProvisioned, Status: corev1.ConditionFalse, Reason: polv1.ReasonFailed,
+      Message: "rules could not be provisioned into some of the selected Pods on nodes:" + strings.Join(failedNodes, ",")}
+  }
+  status.Conditions = setCondition(status.Conditions, provisionedCondition)
+  return status
+}
+
+//calculateNodeStatus counts the local Pods selected by the policy, and the ones its rules could not be provisioned into
+func (netpolCtrl *NetPolControl) calculateNodeStatus(polUid types.UID) polv1.NodeStatus {
+  nodeStatus := polv1.NodeStatus{NodeName: ControllerNode}
+  failedPods := make([]string, 0)
+  podErrors := make(map[string]string)
+  netpolCtrl.provisioningResults.Range(func(key, value interface{}) bool {
+    result := value.(provisioningResult)
+    if _, ok := result.PolicyKeys[polUid]; !ok {
+      return true
+    }
+    nodeStatus.SelectedPods++
+    if result.Err == "" {
+      nodeStatus.ProvisionedPods++
+    } else {
+      nodeStatus.FailedPods++
+      failedPods = append(failedPods, key.(string))
+      podErrors[key.(string)] = result.Err
+    }
+    return true
+  })
+  //The same Pod is always reported, so the status does not change until the Pod is fixed
+  if len(failedPods) > 0 {
+    sort.Strings(failedPods)
+    nodeStatus.Message = "Pod:" + failedPods[0] + " failed with error:" + podErrors[failedPods[0]]
+  }
+  return nodeStatus
+}
+
+//setCondition replaces the condition of the same type, keeping its transition time when its status did not change
+func setCondition(conditions []polv1.NetPolCondition, newCondition polv1.NetPolCondition) []polv1.NetPolCondition {
+  newCondition.LastTransitionTime = metav1.Now()
+  for i, condition := range conditions {
+    if condition.Type != newCondition.Type {
+      continue
+    }
+    if condition.Status == newCondition.Status {
+      newCondition.LastTransitionTime = condition.LastTransitionTime
+    }
+    conditions[i] = newCondition
+    return conditions
+  }
+  return append(conditions, newCondition)
+}
+
+//setNodeStatus replaces the status of the node, keeping its update time when the results did not change
+//Nodes not hosting any selected Pods are removed from the list
+func setNodeStatus(nodes []polv1.NodeStatus, newNodeStatus polv1.NodeStatus) []polv1.NodeStatus {
+  newNodeStatus.LastUpdateTime = metav1.Now()
+  newNodes := make([]polv1.NodeStatus, 0)
+  for _, nodeStatus := range nodes {
+    if nodeStatus.NodeName != newNodeStatus.NodeName {
+      newNodes = append(newNodes, nodeStatus)
+    } else if isSameNodeResult(nodeStatus, newNodeStatus) {
+      newNodeStatus.LastUpdateTime = nodeStatus.LastUpdateTime
+    }
+  }
+  if newNodeStatus.SelectedPods > 0 {
+    newNodes = append(newNodes, newNodeStatus)
+  }
+  if len(newNodes) == 0 {
+    return nil
+  }
+  sort.Slice(newNodes, func(i, j int) bool {
+    return newNodes[i].NodeName < newNodes[j].NodeName
+  })
+  return newNodes
+}
+
+func isSameNodeResult(nodeStatus, newNodeStatus polv1.NodeStatus) bool {
+  return nodeStatus.SelectedPods == newNodeStatus.SelectedPods && nodeStatus.ProvisionedPods == newNodeStatus.ProvisionedPods &&
+         nodeStatus.FailedPods == newNodeStatus.FailedPods && nodeStatus.Message == newNodeStatus.Message
+}
\ No newline at end of file
@@ -2,67 +2,215 @@ package polset
 
 import (
   "log"
-  "context"
+  "sort"
   polv1 "github.com/nokia/danm-utils/crd/api/netpol/v1"
-  polclientset "github.com/nokia/danm-utils/crd/client/clientset/versioned"
+  "github.com/nokia/danm-utils/pkg/convert"
   "github.com/nokia/danm-utils/types/poltypes"
   corev1 "k8s.io/api/core/v1"
+  networkingv1 "k8s.io/api/networking/v1"
   metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+  "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+  "k8s.io/apimachinery/pkg/labels"
+  "k8s.io/apimachinery/pkg/runtime"
+  "k8s.io/client-go/tools/cache"
 )
 
+const (
+  BucketIndex = "bucket"
+)
+
+//Indexers sort the DanmNetworkPolicies of an informer into the same buckets as the ones used by PolicySet, prefixed with their namespace
+//The informer keeps the buckets up-to-date on every policy event, so they never have to be re-built when the policies of a Pod are looked-up
+var Indexers = cache.Indexers {
+  BucketIndex: bucketIndexFunc,
+}
+
+func bucketIndexFunc(obj interface{}) ([]string, error) {
+  policy, ok := obj.(*polv1.DanmNetworkPolicy)
+  if !ok {
+    return nil, nil
+  }
+  buckets := make([]string, 0)
+  for _, bucket := range getPolicyBuckets(*policy) {
+    buckets = append(buckets, policy.ObjectMeta.Namespace + "/" + bucket)
+  }
+  return buckets, nil
+}
+
 type PolicySet struct {
-  NetPols map[string][]polv1.DanmNetworkPolicy
+  //NetPols holds the policies added to the set on top of the ones in the informer cache, sorted into the same buckets
+  NetPols   map[string][]polv1.DanmNetworkPolicy
+  indexer   cache.Indexer
+  namespace string
 }
 
-func NewPolicySet(netpolClient polclientset.Interface, namespace string) *PolicySet {
-  var polSet PolicySet
-  polSet.NetPols = make(map[string][]polv1.DanmNetworkPolicy, 0)
-  netPols, err := netpolClient.NetpolV1().DanmNetworkPolicies(namespace).List(context.TODO(), metav1.ListOptions{})
-  if err != nil {
-    log.Println("ERROR: can't list DANM NetworkPolicies API because:" + err.Error())
-    return &polSet
+//NewPolicySet creates the PolicySet of the namespace from the cache of a DanmNetworkPolicy informer having the Indexers of this package
+func NewPolicySet(polIndexer cache.Indexer, namespace string) *PolicySet {
+  return &PolicySet {
+    NetPols:   make(map[string][]polv1.DanmNetworkPolicy, 0),
+    indexer:   polIndexer,
+    namespace: namespace,
   }
-  polSet.NetPols = sortPoliciesIntoBuckets(netPols.Items)
-  return &polSet
 }
 
-func sortPoliciesIntoBuckets(netPols []polv1.DanmNetworkPolicy) map[string][]polv1.DanmNetworkPolicy {
-  polBuckets := make(map[string][]polv1.DanmNetworkPolicy, 0)
-  for _, policy := range netPols {
-    selectors, err := metav1.LabelSelectorAsMap(&policy.Spec.PodSelector)
+//getBucket returns the policies of the bucket from the informer cache ordered by their names, followed by the ones added to the set
+func (polSet *PolicySet) getBucket(bucket string) []polv1.DanmNetworkPolicy {
+  policies := make([]polv1.DanmNetworkPolicy, 0)
+  if polSet.indexer != nil {
+    objs, err := polSet.indexer.ByIndex(BucketIndex, polSet.namespace + "/" + bucket)
     if err != nil {
-      log.Println("WARNING: PodSelector field of DanmNetworkPolicy:" + policy.ObjectMeta.Name + " in namespace:" +
-        policy.ObjectMeta.Namespace + " could not be parsed and is therefore ignored because of error:" + err.Error())
-      continue
+      log.Println("ERROR: can't get DanmNetworkPolicies from bucket:" + bucket + " in namespace:" + polSet.namespace + " because:" + err.Error())
     }
-    if len(selectors) == 0 {
-      //From K8s documentation: "an empty podSelector selects all pods in the namespace"
-      polBuckets[poltypes.DefaultBucketName] = append(polBuckets[poltypes.DefaultBucketName], policy)
-    } else {
-      for key, value := range selectors {
-        polBuckets[key+value+poltypes.CustomBucketPostfix] = append(polBuckets[key+value+poltypes.CustomBucketPostfix], policy)
+    for _, obj := range objs {
+      if policy, ok := obj.(*polv1.DanmNetworkPolicy); ok {
+        policies = append(policies, *policy)
       }
     }
+    //Indices return their objects in random order, but the rules calculated from them must not change between two calculations
+    sort.Slice(policies, func(i, j int) bool {
+      return policies[i].ObjectMeta.Name < policies[j].ObjectMeta.Name
+    })
+  }
+  return append(policies, polSet.NetPols[bucket]...)
+}
+
+//AddClusterPolicies merges the ClusterDanmNetworkPolicies selecting the namespace into the PolicySet of the namespace
+func (polSet *PolicySet) AddClusterPolicies(clusterPols []polv1.ClusterDanmNetworkPolicy, namespace string, namespaceLabels map[string]string) {
+  for bucket, policies := range sortPoliciesIntoBuckets(FilterClusterPolicies(clusterPols, namespace, namespaceLabels)) {
+    polSet.NetPols[bucket] = append(polSet.NetPols[bucket], policies...)
+  }
+}
+
+//FilterClusterPolicies returns the ClusterDanmNetworkPolicies whose NamespaceSelector selects the namespace
+//They are converted into DanmNetworkPolicies of the namespace, so peers without a namespaceSelector refer to the namespace of the isolated Pod
+func FilterClusterPolicies(clusterPols []polv1.ClusterDanmNetworkPolicy, namespace string, namespaceLabels map[string]string) []polv1.DanmNetworkPolicy {
+  netPols := make([]polv1.DanmNetworkPolicy, 0)
+  for _, clusterPol := range clusterPols {
+    selector, err := metav1.LabelSelectorAsSelector(&clusterPol.Spec.NamespaceSelector)
+    if err != nil {
+      log.Println("WARNING: NamespaceSelector field of ClusterDanmNetworkPolicy:" + clusterPol.ObjectMeta.Name +
+        " could not be parsed and is therefore ignored because of error:" + err.Error())
+      continue
+    }
+    if !selector.Matches(labels.Set(namespaceLabels)) {
+      continue
+    }
+    netPol := polv1.DanmNetworkPolicy {
+      ObjectMeta: *clusterPol.ObjectMeta.DeepCopy(),
+      Spec: *clusterPol.Spec.NetPolSpec.DeepCopy(),
+    }
+    netPol.ObjectMeta.Namespace = namespace
+    netPols = append(netPols, netPol)
+  }
+  return netPols
+}
+
+//AddNetworkPolicies merges the mirrored upstream NetworkPolicies of the namespace into the PolicySet of the namespace
+func (polSet *PolicySet) AddNetworkPolicies(netPols []unstructured.Unstructured) {
+  for bucket, policies := range sortPoliciesIntoBuckets(ConvertNetworkPolicies(netPols)) {
+    polSet.NetPols[bucket] = append(polSet.NetPols[bucket], policies...)
+  }
+}
+
+//ConvertNetworkPolicies translates upstream NetworkPolicies into DanmNetworkPolicies without network selectors, so they apply to all the interfaces of the Pods
+//The UIDs of the NetworkPolicies are kept, so they are never mistaken for a DanmNetworkPolicy with the same name
+//The unstructured content of the NetworkPolicies is also used in the conversion, as our NetworkPolicy type does not know about every field, e.g. about endPort
+func ConvertNetworkPolicies(rawPols []unstructured.Unstructured) []polv1.DanmNetworkPolicy {
+  danmNetPols := make([]polv1.DanmNetworkPolicy, 0)
+  for _, rawPol := range rawPols {
+    var netPol networkingv1.NetworkPolicy
+    err := runtime.DefaultUnstructuredConverter.FromUnstructured(rawPol.Object, &netPol)
+    if err != nil {
+      log.Println("WARNING: NetworkPolicy:" + rawPol.GetName() + " in namespace:" + rawPol.GetNamespace() + " can't be mirrored because:" + err.Error())
+      continue
+    }
+    danmNetPol, issues := convert.ConvertNetworkPolicy(netPol, rawPol.Object, convert.Options{SelectNamespacePods: true})
+    for _, issue := range issues {
+      log.Println("WARNING: NetworkPolicy can't be fully mirrored because " + issue.String())
+    }
+    danmNetPol.ObjectMeta.UID = netPol.ObjectMeta.UID
+    danmNetPols = append(danmNetPols, danmNetPol)
+  }
+  return danmNetPols
+}
+
+//sortPoliciesIntoBuckets indexes the policies by the matchLabels of their PodSelectors
+func sortPoliciesIntoBuckets(netPols []polv1.DanmNetworkPolicy) map[string][]polv1.DanmNetworkPolicy {
+  polBuckets := make(map[string][]polv1.DanmNetworkPolicy, 0)
+  for _, policy := range netPols {
+    for _, bucket := range getPolicyBuckets(policy) {
+      polBuckets[bucket] = append(polBuckets[bucket], policy)
+    }
   }
   return polBuckets
 }
 
+//getPolicyBuckets returns the buckets of the policy based on the matchLabels of its PodSelector
+//Policies having matchExpressions can't be indexed, they are put into a separate bucket and are evaluated one-by-one
+func getPolicyBuckets(policy polv1.DanmNetworkPolicy) []string {
+  if len(policy.Spec.PodSelector.MatchExpressions) > 0 {
+    return []string{poltypes.ExpressionBucketName}
+  }
+  selectors, err := metav1.LabelSelectorAsMap(&policy.Spec.PodSelector)
+  if err != nil {
+    log.Println("WARNING: PodSelector field of DanmNetworkPolicy:" + policy.ObjectMeta.Name + " in namespace:" +
+      policy.ObjectMeta.Namespace + " could not be parsed and is therefore ignored because of error:" + err.Error())
+    return nil
+  }
+  //From K8s documentation: "an empty podSelector selects all pods in the namespace"
+  if len(selectors) == 0 {
+    return []string{poltypes.DefaultBucketName}
+  }
+  buckets := make([]string, 0)
+  for key, value := range selectors {
+    buckets = append(buckets, key+value+poltypes.CustomBucketPostfix)
+  }
+  return buckets
+}
+
 func (polSet *PolicySet) FilterApplicablePolicies(pod *corev1.Pod) []polv1.DanmNetworkPolicy {
   polUidCache := make(poltypes.UidCache, 0)
   applicablePolicies := make([]polv1.DanmNetworkPolicy, 0)
   for key, value := range pod.ObjectMeta.Labels {
-    //there are NetworkPolicies selecting the Pod cause a bucket for this specific label exists
-    if policies, ok := polSet.NetPols[key+value+poltypes.CustomBucketPostfix]; ok {
-      applicablePolicies, polUidCache = filterPoliciesWithoutDupes(policies, applicablePolicies, polUidCache)
+    //there might be NetworkPolicies selecting the Pod cause a bucket for this specific label exists
+    //the bucket only contains the candidates: a policy only selects the Pod if all of its labels match
+    if policies := polSet.getBucket(key+value+poltypes.CustomBucketPostfix); len(policies) > 0 {
+      applicablePolicies, polUidCache = filterPoliciesWithoutDupes(filterMatchingPolicies(policies, pod), applicablePolicies, polUidCache)
     }
   }
-  //Default policies are selecting all Pods in the namespace, so if the default bucket exists we need to treat all pols in it as applicable
-  if policies, ok := polSet.NetPols[poltypes.DefaultBucketName]; ok {
-    applicablePolicies, polUidCache = filterPoliciesWithoutDupes(policies, applicablePolicies, polUidCache)
-  }
+  //Default policies are selecting all Pods in the namespace, so we need to treat all pols in the default bucket as applicable
+  applicablePolicies, polUidCache = filterPoliciesWithoutDupes(polSet.getBucket(poltypes.DefaultBucketName), applicablePolicies, polUidCache)
+  applicablePolicies, polUidCache = filterPoliciesWithoutDupes(filterMatchingPolicies(polSet.getBucket(poltypes.ExpressionBucketName), pod), applicablePolicies, polUidCache)
+  //Labels of the Pod are iterated in random order, but the rules calculated from the policies must not change between two calculations
+  sort.SliceStable(applicablePolicies, func(i, j int) bool {
+    if applicablePolicies[i].ObjectMeta.Namespace != applicablePolicies[j].ObjectMeta.Namespace {
+      return applicablePolicies[i].ObjectMeta.Namespace < applicablePolicies[j].ObjectMeta.Namespace
+    }
+    if applicablePolicies[i].ObjectMeta.Name != applicablePolicies[j].ObjectMeta.Name {
+      return applicablePolicies[i].ObjectMeta.Name < applicablePolicies[j].ObjectMeta.Name
+    }
+    return applicablePolicies[i].ObjectMeta.UID < applicablePolicies[j].ObjectMeta.UID
+  })
   return applicablePolicies
 }
 
+//filterMatchingPolicies returns the policies whose PodSelector fully matches the labels of the Pod
+func filterMatchingPolicies(policies []polv1.DanmNetworkPolicy, pod *corev1.Pod) []polv1.DanmNetworkPolicy {
+  matchingPolicies := make([]polv1.DanmNetworkPolicy, 0)
+  for _, policy := range policies {
+    selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.PodSelector)
+    if err != nil {
+      log.Println("WARNING: PodSelector field of DanmNetworkPolicy:" + policy.ObjectMeta.Name + " in namespace:" +
+        policy.ObjectMeta.Namespace + " could not be parsed and is therefore ignored because of error:" + err.Error())
+      continue
+    }
+    if selector.Matches(labels.Set(pod.ObjectMeta.Labels)) {
+      matchingPolicies = append(matchingPolicies, policy)
+    }
+  }
+  return matchingPolicies
+}
+
 func filterPoliciesWithoutDupes(policies, applicablePolicies []polv1.DanmNetworkPolicy, podUidCache poltypes.UidCache) ([]polv1.DanmNetworkPolicy,poltypes.UidCache){
   for _, policy := range policies {
     //the same policy might select the Pod multiple times via different labels
@@ -73,4 +221,16 @@ func filterPoliciesWithoutDupes(policies, applicablePolicies []polv1.DanmNetwork
     }
   }
   return applicablePolicies, podUidCache
+}
+
+//FilterSelectedPods is the reverse of FilterApplicablePolicies: it returns the Pods from the provided list which are selected by any of the provided policies
+func FilterSelectedPods(netPols []polv1.DanmNetworkPolicy, pods []*corev1.Pod) []*corev1.Pod {
+  polSet := PolicySet{NetPols: sortPoliciesIntoBuckets(netPols)}
+  selectedPods := make([]*corev1.Pod, 0)
+  for _, pod := range pods {
+    if len(polSet.FilterApplicablePolicies(pod)) > 0 {
+      selectedPods = append(selectedPods, pod)
+    }
+  }
+  return selectedPods
 }
\ No newline at end of file
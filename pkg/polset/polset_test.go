@@ -0,0 +1,208 @@
+package polset
+
+import (
+  "sort"
+  "testing"
+  polv1 "github.com/nokia/danm-utils/crd/api/netpol/v1"
+  "github.com/nokia/danm-utils/pkg/convert"
+  corev1 "k8s.io/api/core/v1"
+  networkingv1 "k8s.io/api/networking/v1"
+  metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+  "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+  "k8s.io/apimachinery/pkg/runtime"
+  "k8s.io/apimachinery/pkg/types"
+  "k8s.io/client-go/tools/cache"
+)
+
+const (
+  testNamespace = "default"
+)
+
+var (
+  testPolicies = []runtime.Object {
+    newTestPolicy("db-prod", metav1.LabelSelector{MatchLabels: map[string]string{"app": "db", "tier": "prod"}}),
+    newTestPolicy("db", metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}),
+    newTestPolicy("all", metav1.LabelSelector{}),
+    newTestPolicy("not-dev", metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+      {Key: "tier", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"dev"}},
+    }}),
+    newTestPolicy("db-exists", metav1.LabelSelector{
+      MatchLabels: map[string]string{"app": "db"},
+      MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tier", Operator: metav1.LabelSelectorOpExists}},
+    }),
+    newTestPolicy("other-namespace", metav1.LabelSelector{}),
+  }
+)
+
+var filterApplicablePoliciesTcs = []struct {
+  tcName string
+  podLabels map[string]string
+  expectedPolicies []string
+}{
+  {"noLabels", nil, []string{"all", "not-dev"}},
+  {"allLabelsMatch", map[string]string{"app": "db", "tier": "prod"}, []string{"all", "db", "db-exists", "db-prod", "not-dev"}},
+  {"onlyOneLabelMatches", map[string]string{"app": "web", "tier": "prod"}, []string{"all", "not-dev"}},
+  {"otherLabelMatches", map[string]string{"app": "db", "tier": "dev"}, []string{"all", "db", "db-exists"}},
+  {"extraLabels", map[string]string{"app": "db", "tier": "prod", "zone": "a"}, []string{"all", "db", "db-exists", "db-prod", "not-dev"}},
+}
+
+func TestFilterApplicablePolicies(t *testing.T) {
+  polSet := NewPolicySet(newTestPolicyIndexer(testPolicies...), testNamespace)
+  for _, tc := range filterApplicablePoliciesTcs {
+    t.Run(tc.tcName, func(t *testing.T) {
+      pod := newTestPod("pod", tc.podLabels)
+      policyNames := getPolicyNames(polSet.FilterApplicablePolicies(pod))
+      if !isEqual(policyNames, tc.expectedPolicies) {
+        t.Errorf("Applicable policies:%v do not match the expected:%v", policyNames, tc.expectedPolicies)
+      }
+    })
+  }
+}
+
+func TestFilterSelectedPods(t *testing.T) {
+  policies := []polv1.DanmNetworkPolicy{*testPolicies[0].(*polv1.DanmNetworkPolicy)}
+  pods := []*corev1.Pod {
+    newTestPod("db-prod", map[string]string{"app": "db", "tier": "prod"}),
+    newTestPod("db-dev", map[string]string{"app": "db", "tier": "dev"}),
+    newTestPod("web-prod", map[string]string{"app": "web", "tier": "prod"}),
+  }
+  selectedPods := FilterSelectedPods(policies, pods)
+  if len(selectedPods) != 1 || selectedPods[0].ObjectMeta.Name != "db-prod" {
+    t.Errorf("Only Pod db-prod should have been selected, but we got:%v", selectedPods)
+  }
+}
+
+//The buckets of the PolicySet are always served from the current state of the informer cache
+func TestPolicySetFollowsIndexer(t *testing.T) {
+  polIndexer := newTestPolicyIndexer(testPolicies...)
+  polSet := NewPolicySet(polIndexer, testNamespace)
+  pod := newTestPod("pod", map[string]string{"app": "web", "tier": "dev"})
+  expectedPolicies := []string{"all"}
+  if policyNames := getPolicyNames(polSet.FilterApplicablePolicies(pod)); !isEqual(policyNames, expectedPolicies) {
+    t.Fatalf("Applicable policies:%v do not match the expected:%v", policyNames, expectedPolicies)
+  }
+  relabeledPolicy := newTestPolicy("db", metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}})
+  polIndexer.Update(relabeledPolicy)
+  polIndexer.Delete(newTestPolicy("all", metav1.LabelSelector{}))
+  expectedPolicies = []string{"db"}
+  if policyNames := getPolicyNames(polSet.FilterApplicablePolicies(pod)); !isEqual(policyNames, expectedPolicies) {
+    t.Errorf("Applicable policies:%v after updating the informer cache do not match the expected:%v", policyNames, expectedPolicies)
+  }
+}
+
+func TestAddClusterPolicies(t *testing.T) {
+  clusterPolicies := []polv1.ClusterDanmNetworkPolicy {
+    newTestClusterPolicy("cluster-all", metav1.LabelSelector{}, metav1.LabelSelector{}),
+    newTestClusterPolicy("cluster-db", metav1.LabelSelector{}, metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}),
+    newTestClusterPolicy("cluster-tenant", metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "true"}}, metav1.LabelSelector{}),
+  }
+  polSet := NewPolicySet(newTestPolicyIndexer(testPolicies...), testNamespace)
+  polSet.AddClusterPolicies(clusterPolicies, testNamespace, map[string]string{"tenant": "false"})
+  applicablePolicies := polSet.FilterApplicablePolicies(newTestPod("pod", map[string]string{"app": "db", "tier": "dev"}))
+  expectedPolicies := []string{"all", "cluster-all", "cluster-db", "db", "db-exists"}
+  if policyNames := getPolicyNames(applicablePolicies); !isEqual(policyNames, expectedPolicies) {
+    t.Errorf("Applicable policies:%v do not match the expected:%v", policyNames, expectedPolicies)
+  }
+  for _, policy := range applicablePolicies {
+    if policy.ObjectMeta.Namespace != testNamespace {
+      t.Errorf("Policy:%s should have been converted into the namespace of the Pod, but it is in namespace:%s", policy.ObjectMeta.Name, policy.ObjectMeta.Namespace)
+    }
+  }
+}
+
+func TestAddNetworkPolicies(t *testing.T) {
+  netPols := []unstructured.Unstructured {
+    newTestNetworkPolicy("db", metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}),
+    newTestNetworkPolicy("web", metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}),
+  }
+  //Our NetworkPolicy type does not know about endPort, so it is only present in the unstructured object, just like when it comes from the API server
+  netPols[0].Object["spec"].(map[string]interface{})["ingress"].([]interface{})[0].(map[string]interface{})["ports"] = []interface{}{
+    map[string]interface{}{"protocol": "TCP", "port": int64(8000), "endPort": int64(8080)},
+  }
+  polSet := NewPolicySet(newTestPolicyIndexer(testPolicies...), testNamespace)
+  polSet.AddNetworkPolicies(netPols)
+  applicablePolicies := polSet.FilterApplicablePolicies(newTestPod("pod", map[string]string{"app": "db", "tier": "dev"}))
+  //The mirrored NetworkPolicy does not replace the DanmNetworkPolicy with the same name
+  expectedPolicies := []string{"all", "db", "db", "db-exists"}
+  if policyNames := getPolicyNames(applicablePolicies); !isEqual(policyNames, expectedPolicies) {
+    t.Errorf("Applicable policies:%v do not match the expected:%v", policyNames, expectedPolicies)
+  }
+  for _, policy := range applicablePolicies {
+    if policy.ObjectMeta.UID != "netpol-db" {
+      continue
+    }
+    peer := policy.Spec.Ingress[0].From[0]
+    if len(peer.NetworkSelector) > 0 || len(peer.PodSelector.MatchExpressions) != 1 || peer.PodSelector.MatchExpressions[0].Key != convert.NamespacePodsSelectorKey {
+      t.Errorf("Empty podSelector of the mirrored NetworkPolicy should have been converted into one selecting all the Pods of the namespace, but we got:%+v", peer)
+    }
+    ports := policy.Spec.Ingress[0].Ports
+    if len(ports) != 1 || ports[0].EndPort == nil || *ports[0].EndPort != 8080 {
+      t.Errorf("endPort of the mirrored NetworkPolicy should have been kept, but we got ports:%+v", ports)
+    }
+  }
+}
+
+func newTestPolicyIndexer(policies ...runtime.Object) cache.Indexer {
+  polIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, Indexers)
+  for _, policy := range policies {
+    polIndexer.Add(policy)
+  }
+  return polIndexer
+}
+
+func newTestPolicy(name string, podSelector metav1.LabelSelector) *polv1.DanmNetworkPolicy {
+  namespace := testNamespace
+  if name == "other-namespace" {
+    namespace = "other"
+  }
+  return &polv1.DanmNetworkPolicy {
+    ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: types.UID(name)},
+    Spec: polv1.NetPolSpec{PodSelector: podSelector},
+  }
+}
+
+func newTestClusterPolicy(name string, namespaceSelector, podSelector metav1.LabelSelector) polv1.ClusterDanmNetworkPolicy {
+  return polv1.ClusterDanmNetworkPolicy {
+    ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID(name)},
+    Spec: polv1.ClusterNetPolSpec{NamespaceSelector: namespaceSelector, NetPolSpec: polv1.NetPolSpec{PodSelector: podSelector}},
+  }
+}
+
+func newTestNetworkPolicy(name string, podSelector metav1.LabelSelector) unstructured.Unstructured {
+  netPol := networkingv1.NetworkPolicy {
+    ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, UID: types.UID("netpol-" + name)},
+    Spec: networkingv1.NetworkPolicySpec {
+      PodSelector: podSelector,
+      Ingress: []networkingv1.NetworkPolicyIngressRule{{From: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}}}},
+    },
+  }
+  rawPol, _ := runtime.DefaultUnstructuredConverter.ToUnstructured(&netPol)
+  return unstructured.Unstructured{Object: rawPol}
+}
+
+func newTestPod(name string, labels map[string]string) *corev1.Pod {
+  return &corev1.Pod {
+    ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, UID: types.UID(name), Labels: labels},
+  }
+}
+
+func getPolicyNames(policies []polv1.DanmNetworkPolicy) []string {
+  names := make([]string, 0)
+  for _, policy := range policies {
+    names = append(names, policy.ObjectMeta.Name)
+  }
+  sort.Strings(names)
+  return names
+}
+
+func isEqual(names, expectedNames []string) bool {
+  if len(names) != len(expectedNames) {
+    return false
+  }
+  for i := range names {
+    if names[i] != expectedNames[i] {
+      return false
+    }
+  }
+  return true
+}
\ No newline at end of file
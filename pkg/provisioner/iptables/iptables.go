@@ -1,65 +1,52 @@
 package iptables
 
 import (
-  "log"
-  "runtime"
-  "github.com/containernetworking/plugins/pkg/ns"
+  "bytes"
+  "errors"
+  "net"
+  "sort"
+  "strings"
+  "github.com/nokia/danm-utils/pkg/provisioner/podns"
   "github.com/nokia/danm-utils/types/poltypes"
   corev1 "k8s.io/api/core/v1"
   k8stables "k8s.io/kubernetes/pkg/util/iptables"
   "k8s.io/utils/exec"
 )
 
+const (
+  //OwnChainPrefix starts the name of every chain created by Policer. Chains with this prefix are removed from the Pods when Policer does not need them anymore
+  OwnChainPrefix = "DANM_"
+  InputChainName = "DANM_INPUT"
+  OutputChainName = "DANM_OUTPUT"
+  ForwardChainName = "DANM_FORWARD"
+)
+
 var (
   DefaultInputRules = poltypes.NetRuleChain {
-    Name: string(k8stables.ChainInput), Rules: []poltypes.NetRule {
-      //Allow localhost communication by default
-      poltypes.NetRule{SourceIface: "lo", Operation: poltypes.IptablesAccept,},
-      //Allow bi-directional communication through connections already established with trusted Egress entities
-      poltypes.NetRule{State: poltypes.StateEstablishedRelated, Operation: poltypes.IptablesAccept,},
-      poltypes.NetRule{Operation: poltypes.IptablesReject,},
-    },
+    Name: InputChainName, Rules: poltypes.DefaultIngressRules,
   }
   DefaultOutputRules = poltypes.NetRuleChain {
-    Name: string(k8stables.ChainOutput), Rules: []poltypes.NetRule {
-      //Allow localhost communication by default
-      poltypes.NetRule{DestIface: "lo", Operation: poltypes.IptablesAccept,},
-      //Allow bi-directional communication through connections already established with trusted Ingress entities
-      poltypes.NetRule{State: poltypes.StateEstablishedRelated, Operation: poltypes.IptablesAccept,},
-      //Allow outgoing cluster DNS communication by default. Can happen via either TCP, or UDP
-      poltypes.NetRule{Protocol: "tcp", DestPort: "53", State: poltypes.StateNewEstablished, Operation: poltypes.IptablesAccept,},
-      poltypes.NetRule{Protocol: "udp", DestPort: "53", State: poltypes.StateNewEstablished, Operation: poltypes.IptablesAccept,},
-      poltypes.NetRule{Operation: poltypes.IptablesReject,},
-    },
+    Name: OutputChainName, Rules: poltypes.DefaultEgressRules,
   }
   DefaultForwardRules = poltypes.NetRuleChain {
-    Name: string(k8stables.ChainForward), Rules: []poltypes.NetRule {
-      poltypes.NetRule{Operation: poltypes.IptablesReject,},
-    },
-  }
-  JumpToV4IngressRule = poltypes.NetRuleChain {
-    Name: string(k8stables.ChainInput), Rules: []poltypes.NetRule {
-      poltypes.NetRule{Operation: poltypes.IngressV4ChainName,},
-    },
-  }
-  JumpToV4EgressRule = poltypes.NetRuleChain {
-    Name: string(k8stables.ChainOutput), Rules: []poltypes.NetRule {
-      poltypes.NetRule{Operation: poltypes.EgressV4ChainName,},
-    },
-  }
-  JumpToV6IngressRule = poltypes.NetRuleChain {
-    Name: string(k8stables.ChainInput), Rules: []poltypes.NetRule {
-      poltypes.NetRule{Operation: poltypes.IngressV6ChainName,},
-    },
-  }
-  JumpToV6EgressRule = poltypes.NetRuleChain {
-    Name: string(k8stables.ChainOutput), Rules: []poltypes.NetRule {
-      poltypes.NetRule{Operation: poltypes.EgressV6ChainName,},
-    },
+    Name: ForwardChainName, Rules: poltypes.DefaultForwardRules,
   }
   DefaultReturnRule = poltypes.NetRule {
     Operation: poltypes.IptablesReturn,
   }
+  //BuiltinChains are shared with the other users of the filter table, Policer only adds one jump rule to each of them towards its own top level chain
+  BuiltinChains = []string{string(k8stables.ChainInput), string(k8stables.ChainOutput), string(k8stables.ChainForward)}
+  TopLevelChains = map[string]string {
+    string(k8stables.ChainInput): InputChainName,
+    string(k8stables.ChainOutput): OutputChainName,
+    string(k8stables.ChainForward): ForwardChainName,
+  }
+  //legacyDefaultRules are the default rules earlier Policer versions appended directly to the built-in chains
+  legacyDefaultRules = map[string][]poltypes.NetRule {
+    string(k8stables.ChainInput): poltypes.DefaultIngressRules,
+    string(k8stables.ChainOutput): poltypes.DefaultEgressRules,
+    string(k8stables.ChainForward): poltypes.DefaultForwardRules,
+  }
 )
 
 type IptablesProvisioner struct {
@@ -76,99 +63,366 @@ func NewIptablesProvisioner() *IptablesProvisioner {
   return &iptablesProv
 }
 
-func (iptabProv *IptablesProvisioner) AddRulesToNewPod(ruleSet *poltypes.NetRuleSet, pod *corev1.Pod) {
-  runtime.LockOSThread()
-  defer runtime.UnlockOSThread()
-  origns, err := ns.GetCurrentNS()
+//ownChain is a chain created by Policer, with its rules rendered into iptables arguments
+type ownChain struct {
+  Name  string
+  Rules []string
+}
+
+//filterTable is the filter table of a Pod as listed by iptables-save: the declared chains, and the arguments of the rules of every chain
+type filterTable struct {
+  Chains []string
+  Rules  map[string][]string
+}
+
+func (table filterTable) hasChain(chainName string) bool {
+  for _, savedChain := range table.Chains {
+    if savedChain == chainName {
+      return true
+    }
+  }
+  return false
+}
+
+//AddRulesToPod renders the whole NetRuleSet together with the default rules into one iptables-restore payload per IP family
+//This way the policy of the Pod flips atomically: there is no window when only a partial whitelist, or only the default REJECT rules are present
+func (iptabProv *IptablesProvisioner) AddRulesToPod(ruleSet *poltypes.NetRuleSet, pod *corev1.Pod) error {
+  v4Chains := renderOwnChains(ruleSet, ruleSet.IngressV4Chain, ruleSet.EgressV4Chain)
+  v6Chains := renderOwnChains(ruleSet, ruleSet.IngressV6Chain, ruleSet.EgressV6Chain)
+  return podns.Execute(ruleSet.Netns, func() error {
+    return provisionChains(iptabProv, v4Chains, v6Chains)
+  })
+}
+
+//RemoveRulesFromPod takes away all isolation from a Pod which is not selected by any network policies anymore
+//Only the Netns of the provided RuleSet is used, all Policer created rules and chains are removed from the Pod regardless of the content of the RuleSet
+func (iptabProv *IptablesProvisioner) RemoveRulesFromPod(ruleSet *poltypes.NetRuleSet, pod *corev1.Pod) error {
+  return podns.Execute(ruleSet.Netns, func() error {
+    return provisionChains(iptabProv, nil, nil)
+  })
+}
+
+//IsRuleSetProvisioned compares the filter table saved from the Pod with the chains AddRulesToPod would provision, for both IP families
+func (iptabProv *IptablesProvisioner) IsRuleSetProvisioned(ruleSet *poltypes.NetRuleSet, pod *corev1.Pod) (bool, error) {
+  v4Chains := renderOwnChains(ruleSet, ruleSet.IngressV4Chain, ruleSet.EgressV4Chain)
+  v6Chains := renderOwnChains(ruleSet, ruleSet.IngressV6Chain, ruleSet.EgressV6Chain)
+  var v4Table, v6Table filterTable
+  err := podns.Execute(ruleSet.Netns, func() error {
+    var err error
+    v4Table, v6Table, err = saveTables(iptabProv)
+    return err
+  })
   if err != nil {
-    log.Println("Failed to get the current NS for Pod:" + pod.ObjectMeta.Name +
-      " in ns:" + pod.ObjectMeta.Namespace + "because:" + err.Error())
-    return
+    return false, err
+  }
+  return isTableProvisioned(v4Chains, v4Table) && isTableProvisioned(v6Chains, v6Table), nil
+}
+
+//isTableProvisioned compares the filter table saved from the Pod with the rendered chains
+//The Pod needs to have exactly the rendered own chains with the same rules -including the marker rule carrying the fingerprint-, and one jump rule in every built-in chain whose top level chain is rendered
+//Foreign rules of the built-in chains are ignored, but rules left behind by Policer -e.g. the default rules of earlier versions- are not allowed there
+func isTableProvisioned(chains []ownChain, savedTable filterTable) bool {
+  isChainNeeded := make(map[string]bool)
+  for _, chain := range chains {
+    isChainNeeded[chain.Name] = true
+    if !savedTable.hasChain(chain.Name) || !areRulesEqual(chain.Rules, savedTable.Rules[chain.Name]) {
+      return false
+    }
+  }
+  for _, chainName := range savedTable.Chains {
+    if strings.HasPrefix(chainName, OwnChainPrefix) && !isChainNeeded[chainName] {
+      return false
+    }
+  }
+  for _, builtinChain := range BuiltinChains {
+    isJumpNeeded := isChainNeeded[TopLevelChains[builtinChain]]
+    policerRules, hasJump := findPolicerRules(builtinChain, savedTable.Rules[builtinChain], isJumpNeeded)
+    if len(policerRules) > 0 || hasJump != isJumpNeeded {
+      return false
+    }
+  }
+  return true
+}
+
+//areRulesEqual compares the rendered rules of a chain with the saved ones, so rules modified in place are noticed just as well as missing, or extra rules
+func areRulesEqual(rules, savedRules []string) bool {
+  if len(rules) != len(savedRules) {
+    return false
+  }
+  for i := range rules {
+    if normalizeRule(rules[i]) != normalizeRule(savedRules[i]) {
+      return false
+    }
+  }
+  return true
+}
+
+//findPolicerRules returns the rules of a built-in chain created by Policer, except for the first jump rule towards the top level chain when that one is still needed
+//Besides jump rules towards own chains, and marker rules, the default rules appended directly to the built-in chains by earlier Policer versions are also returned
+//Default rules can't be told apart from similar foreign rules, so they are only returned when the chain contains other traces of an earlier Policer as well
+func findPolicerRules(builtinChain string, rules []string, isJumpNeeded bool) ([]string,bool) {
+  topLevelChain := TopLevelChains[builtinChain]
+  hasLegacyRules := false
+  for _, args := range rules {
+    target := getTarget(args)
+    if (strings.HasPrefix(target, OwnChainPrefix) && target != topLevelChain) || strings.Contains(args, poltypes.FingerprintPrefix) ||
+       (target == poltypes.IptablesReject && isLegacyDefaultRule(builtinChain, args)) {
+      hasLegacyRules = true
+      break
+    }
+  }
+  policerRules := make([]string, 0)
+  hasJump := false
+  for _, args := range rules {
+    if isJumpNeeded && !hasJump && normalizeRule(args) == normalizeRule("-j " + topLevelChain) {
+      hasJump = true
+      continue
+    }
+    if strings.HasPrefix(getTarget(args), OwnChainPrefix) || strings.Contains(args, poltypes.FingerprintPrefix) ||
+       (hasLegacyRules && isLegacyDefaultRule(builtinChain, args)) {
+      policerRules = append(policerRules, args)
+    }
+  }
+  return policerRules, hasJump
+}
+
+//isLegacyDefaultRule tells whether the rule is one of the default rules earlier Policer versions appended to the built-in chain
+//The REJECT rules might have been restricted to the isolated interfaces of the Pod
+func isLegacyDefaultRule(builtinChain, args string) bool {
+  rule := normalizeRule(args)
+  if getTarget(args) == poltypes.IptablesReject {
+    rule = normalizeRule(args, "-i", "-o")
+  }
+  for _, defaultRule := range legacyDefaultRules[builtinChain] {
+    if rule == normalizeRule(renderRule(defaultRule)) {
+      return true
+    }
+  }
+  return false
+}
+
+func getTarget(args string) string {
+  fields := strings.Fields(args)
+  for i := 0; i < len(fields)-1; i++ {
+    if fields[i] == "-j" {
+      return fields[i+1]
+    }
+  }
+  return ""
+}
+
+//normalizeRule converts the arguments of a rule into a canonical form, so the rules rendered by Policer can be compared to the ones listed by iptables-save
+//iptables-save adds the match extensions, converts the addresses to CIDRs, reorders the options, and lists the default values of some options, these differences are all evened out
+//Options listed in skippedOptions are left out
+func normalizeRule(args string, skippedOptions ...string) string {
+  options := make([]string, 0)
+  fields := strings.Fields(args)
+  for i := 0; i < len(fields); {
+    option, values := fields[i], make([]string, 0)
+    for i++; i < len(fields) && !strings.HasPrefix(fields[i], "-"); i++ {
+      values = append(values, strings.Trim(fields[i], "\""))
+    }
+    if option == "-m" || isOptionSkipped(option, skippedOptions) {
+      continue
+    }
+    switch option {
+    case "-s", "-d":
+      for j := range values {
+        values[j] = normalizeAddress(values[j])
+      }
+    case "--ctstate":
+      for j := range values {
+        states := strings.Split(values[j], ",")
+        sort.Strings(states)
+        values[j] = strings.Join(states, ",")
+      }
+    case "--reject-with":
+      if len(values) == 1 && (values[0] == "icmp-port-unreachable" || values[0] == "icmp6-port-unreachable") {
+        continue
+      }
+    }
+    options = append(options, strings.TrimSpace(option + " " + strings.Join(values, " ")))
+  }
+  sort.Strings(options)
+  return strings.Join(options, " ")
+}
+
+func isOptionSkipped(option string, skippedOptions []string) bool {
+  for _, skippedOption := range skippedOptions {
+    if option == skippedOption {
+      return true
+    }
+  }
+  return false
+}
+
+//normalizeAddress converts host addresses to CIDRs, and CIDRs to the address of their network
+func normalizeAddress(address string) string {
+  if !strings.Contains(address, "/") {
+    if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+      address += "/128"
+    } else {
+      address += "/32"
+    }
   }
-  hns, err := ns.GetNS(ruleSet.Netns)
+  _, ipNet, err := net.ParseCIDR(address)
   if err != nil {
-    log.Println("Failed to get into Pod's:" + pod.ObjectMeta.Name +" in ns:" + pod.ObjectMeta.Namespace +
-      " netns:" + ruleSet.Netns + " cause of error:" + err.Error())
-    return
-  }
-  defer func() {
-    hns.Close()
-    origns.Set()
-  }()
-  err = hns.Set()
+    return address
+  }
+  return ipNet.String()
+}
+
+//parseTable reads the declared chains, and the rules of every chain from an iptables-save output
+func parseTable(table []byte) filterTable {
+  parsedTable := filterTable{Chains: make([]string, 0), Rules: make(map[string][]string)}
+  for _, line := range strings.Split(string(table), "\n") {
+    fields := strings.Fields(line)
+    if len(fields) > 0 && strings.HasPrefix(fields[0], ":") {
+      parsedTable.Chains = append(parsedTable.Chains, strings.TrimPrefix(fields[0], ":"))
+    } else if len(fields) > 1 && fields[0] == "-A" {
+      parsedTable.Rules[fields[1]] = append(parsedTable.Rules[fields[1]], strings.Join(fields[2:], " "))
+    }
+  }
+  return parsedTable
+}
+
+func saveTables(iptablesProv *IptablesProvisioner) (filterTable,filterTable,error) {
+  var v4Table, v6Table bytes.Buffer
+  err := iptablesProv.V4Provisioner.SaveInto(k8stables.TableFilter, &v4Table)
   if err != nil {
-    log.Println("failed to enter network namespace:" + ruleSet.Netns + " of Pod:" + pod.ObjectMeta.Name +
-      " in ns:" + pod.ObjectMeta.Namespace + "because of error:"+ err.Error())
-    return
+    return filterTable{}, filterTable{}, errors.New("iptables-save failed with error:" + err.Error())
   }
-  err = ensureChains(iptabProv, ruleSet, pod)
+  err = iptablesProv.V6Provisioner.SaveInto(k8stables.TableFilter, &v6Table)
   if err != nil {
-    log.Println("required filter chains could not be created for Pod:" + pod.ObjectMeta.Name +
-      " in ns:" + pod.ObjectMeta.Namespace + " because of error:" + err.Error())
-    return
+    return filterTable{}, filterTable{}, errors.New("ip6tables-save failed with error:" + err.Error())
   }
-  provisionDynamicRules(iptabProv, ruleSet, pod)
-  provisionDefaultRules(iptabProv, pod)
+  return parseTable(v4Table.Bytes()), parseTable(v6Table.Bytes()), nil
 }
 
-func ensureChains(iptablesProv *IptablesProvisioner, ruleSet *poltypes.NetRuleSet, pod *corev1.Pod) error {
-  err := ensureChain(ruleSet.IngressV4Chain, JumpToV4IngressRule, iptablesProv.V4Provisioner, pod)
+//provisionChains replaces the Policer created chains of the Pod with the rendered ones
+//The payloads are rendered against the current filter tables of the Pod, so only the rules created by Policer are touched in the built-in chains
+func provisionChains(iptablesProv *IptablesProvisioner, v4Chains, v6Chains []ownChain) error {
+  v4Table, v6Table, err := saveTables(iptablesProv)
   if err != nil {
     return err
   }
-  err = ensureChain(ruleSet.IngressV6Chain, JumpToV6IngressRule, iptablesProv.V6Provisioner, pod)
+  return restorePayloads(iptablesProv, renderPayload(v4Chains, v4Table), renderPayload(v6Chains, v6Table))
+}
+
+func restorePayloads(iptablesProv *IptablesProvisioner, v4Payload, v6Payload []byte) error {
+  //--noflush is used so the tables, and the chains Policer does not manage are left intact, own chains are flushed by declaring them in the payload
+  err := iptablesProv.V4Provisioner.RestoreAll(v4Payload, k8stables.NoFlushTables, k8stables.NoRestoreCounters)
   if err != nil {
-    return err
+    return errors.New("iptables-restore failed with error:" + err.Error())
   }
-  err = ensureChain(ruleSet.EgressV4Chain, JumpToV4EgressRule, iptablesProv.V4Provisioner, pod)
+  err = iptablesProv.V6Provisioner.RestoreAll(v6Payload, k8stables.NoFlushTables, k8stables.NoRestoreCounters)
   if err != nil {
-    return err
+    return errors.New("ip6tables-restore failed with error:" + err.Error())
   }
-  return ensureChain(ruleSet.EgressV6Chain, JumpToV6EgressRule, iptablesProv.V6Provisioner, pod)
+  return nil
 }
 
-func ensureChain(chain, jumpRule poltypes.NetRuleChain, provisioner k8stables.Interface, pod *corev1.Pod) error {
-  var err error
-  if len(chain.Rules) > 0 {
-    _, err = provisioner.EnsureChain(k8stables.TableFilter, k8stables.Chain(chain.Name))
-    provisioner.FlushChain(k8stables.TableFilter, k8stables.Chain(chain.Name))
-    provisionRulesIntoChain(provisioner, jumpRule, pod)
+//renderOwnChains creates the content of all the Policer created chains of one IP family
+//Default rules and own chains are only provisioned for the isolated directions, and the jump and REJECT rules only match the isolated interfaces
+//No chains are returned when the Pod is not isolated in any direction
+func renderOwnChains(ruleSet *poltypes.NetRuleSet, ingressChain, egressChain poltypes.NetRuleChain) []ownChain {
+  chains := make([]ownChain, 0)
+  if !ruleSet.IsIngressIsolated && !ruleSet.IsEgressIsolated {
+    return chains
+  }
+  if ruleSet.IsIngressIsolated {
+    chains = append(chains, renderDirection(DefaultInputRules, ingressChain, ruleSet.IngressIfaces, true)...)
+  }
+  if ruleSet.IsEgressIsolated {
+    chains = append(chains, renderDirection(DefaultOutputRules, egressChain, ruleSet.EgressIfaces, false)...)
+  }
+  forwardChain := renderChain(DefaultForwardRules)
+  var rendered bytes.Buffer
+  writeChains(&rendered, append(chains, forwardChain))
+  //The marker rule is never reached after the REJECT rule, it only identifies the rules provisioned into the Pod
+  forwardChain.Rules = append(forwardChain.Rules, "-m comment --comment " + poltypes.NewFingerprint(rendered.Bytes()))
+  return append(chains, forwardChain)
+}
+
+//renderDirection creates the top level chain of one direction holding the jump rules and the default rules, and the own chain holding the rules of the policies together with its sub-chains
+//The own chain is left out when the policies do not whitelist anything in this direction
+func renderDirection(defaultRules, chain poltypes.NetRuleChain, ifaces []string, isIngress bool) []ownChain {
+  topLevelRules := poltypes.RestrictDefaultRules(defaultRules.Rules, ifaces, isIngress)
+  if len(chain.Rules) == 0 {
+    return []ownChain{renderChain(poltypes.NetRuleChain{Name: defaultRules.Name, Rules: topLevelRules})}
+  }
+  jumpRules := poltypes.RestrictToIfaces(poltypes.NetRule{Operation: chain.Name}, ifaces, isIngress)
+  topLevelChain := renderChain(poltypes.NetRuleChain{Name: defaultRules.Name, Rules: append(jumpRules, topLevelRules...)})
+  policyChain := renderChain(chain)
+  //We need to add a default "RETURN" rule to the end of our own chains
+  policyChain.Rules = append(policyChain.Rules, renderRule(DefaultReturnRule))
+  chains := []ownChain{topLevelChain, policyChain}
+  //Sub-chains are left at their end, continuing the evaluation of the own chain
+  for _, subChain := range chain.SubChains {
+    chains = append(chains, renderChain(subChain))
   }
-  return err
+  return chains
 }
 
-func provisionDynamicRules(iptablesProv *IptablesProvisioner, ruleSet *poltypes.NetRuleSet, pod *corev1.Pod) {
-  provisionRulesIntoChain(iptablesProv.V4Provisioner, ruleSet.IngressV4Chain, pod)
-  provisionRulesIntoChain(iptablesProv.V6Provisioner, ruleSet.IngressV6Chain, pod)
-  provisionRulesIntoChain(iptablesProv.V4Provisioner, ruleSet.EgressV4Chain, pod)
-  provisionRulesIntoChain(iptablesProv.V6Provisioner, ruleSet.EgressV6Chain, pod)
+func renderChain(chain poltypes.NetRuleChain) ownChain {
+  renderedChain := ownChain{Name: chain.Name, Rules: make([]string, 0)}
+  for _, rule := range chain.Rules {
+    renderedChain.Rules = append(renderedChain.Rules, renderRule(rule))
+  }
+  return renderedChain
 }
 
-func provisionDefaultRules(iptablesProv *IptablesProvisioner, pod *corev1.Pod) {
-  provisionRulesIntoChain(iptablesProv.V4Provisioner, DefaultInputRules, pod)
-  provisionRulesIntoChain(iptablesProv.V4Provisioner, DefaultOutputRules, pod)
-  provisionRulesIntoChain(iptablesProv.V4Provisioner, DefaultForwardRules, pod)
-  provisionRulesIntoChain(iptablesProv.V6Provisioner, DefaultInputRules, pod)
-  provisionRulesIntoChain(iptablesProv.V6Provisioner, DefaultOutputRules, pod)
-  provisionRulesIntoChain(iptablesProv.V6Provisioner, DefaultForwardRules, pod)
+func renderRule(rule poltypes.NetRule) string {
+  return strings.Join(createArgsFromRule(rule), " ")
 }
 
-func provisionRulesIntoChain(provisioner k8stables.Interface, rules poltypes.NetRuleChain, pod *corev1.Pod) {
-  for _, rule := range rules.Rules {
-    args := createArgsFromRule(rule)
-    _, err := provisioner.EnsureRule(k8stables.Append, k8stables.TableFilter, k8stables.Chain(rules.Name), args...)
-    if err != nil {
-      log.Println("ERROR: provisioning iptables rule for Pod: " + pod.ObjectMeta.Name + " in ns: " + pod.ObjectMeta.Namespace + "with args:" + rule.String() +
-        " into chain:" + rules.Name + " failed with error:" + err.Error())
+//renderPayload creates the filter table section of an iptables-restore input for one IP family, replacing the Policer created chains of the Pod with the rendered ones
+//Built-in chains are never flushed: only the rules created by Policer -including the ones left behind by earlier Policer versions- are deleted from them, and one jump rule is inserted towards every rendered top level chain when it is missing
+//Own chains not rendered anymore are flushed, and deleted
+func renderPayload(chains []ownChain, savedTable filterTable) []byte {
+  var payload bytes.Buffer
+  payload.WriteString("*" + string(k8stables.TableFilter) + "\n")
+  isChainNeeded := make(map[string]bool)
+  for _, chain := range chains {
+    isChainNeeded[chain.Name] = true
+    //Declaring a user-defined chain in --noflush mode creates it if it does not exist, and flushes it if it does
+    payload.WriteString(":" + chain.Name + " - [0:0]\n")
+  }
+  staleChains := make([]string, 0)
+  for _, chainName := range savedTable.Chains {
+    if strings.HasPrefix(chainName, OwnChainPrefix) && !isChainNeeded[chainName] {
+      //Stale chains are flushed as well, so they don't reference each other when they are deleted
+      payload.WriteString(":" + chainName + " - [0:0]\n")
+      staleChains = append(staleChains, chainName)
     }
   }
-  //We need to add a default "RETURN" rule to the end of our own chains
-  if rules.Name != string(k8stables.ChainInput) && rules.Name != string(k8stables.ChainOutput) && rules.Name != string(k8stables.ChainForward) && len(rules.Rules) > 0 {
-    args := createArgsFromRule(DefaultReturnRule)
-    _, err := provisioner.EnsureRule(k8stables.Append, k8stables.TableFilter, k8stables.Chain(rules.Name), args...)
-    if err != nil {
-      log.Println("ERROR: provisioning iptables rule for Pod: " + pod.ObjectMeta.Name + " in ns: " + pod.ObjectMeta.Namespace + "with args:" + DefaultReturnRule.String() +
-        " into chain:" + rules.Name + " failed with error:" + err.Error())
+  missingJumps := make([]string, 0)
+  for _, builtinChain := range BuiltinChains {
+    isJumpNeeded := isChainNeeded[TopLevelChains[builtinChain]]
+    policerRules, hasJump := findPolicerRules(builtinChain, savedTable.Rules[builtinChain], isJumpNeeded)
+    for _, args := range policerRules {
+      payload.WriteString("-D " + builtinChain + " " + args + "\n")
+    }
+    if isJumpNeeded && !hasJump {
+      missingJumps = append(missingJumps, builtinChain)
+    }
+  }
+  writeChains(&payload, chains)
+  for _, builtinChain := range missingJumps {
+    //Isolation comes before the rules of other components
+    payload.WriteString("-I " + builtinChain + " 1 -j " + TopLevelChains[builtinChain] + "\n")
+  }
+  for _, chainName := range staleChains {
+    payload.WriteString("-X " + chainName + "\n")
+  }
+  payload.WriteString("COMMIT\n")
+  return payload.Bytes()
+}
+
+func writeChains(payload *bytes.Buffer, chains []ownChain) {
+  for _, chain := range chains {
+    for _, rule := range chain.Rules {
+      payload.WriteString("-A " + chain.Name + " " + rule + "\n")
     }
   }
 }
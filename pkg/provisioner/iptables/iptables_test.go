@@ -0,0 +1,136 @@
+package iptables
+
+import (
+  "strings"
+  "testing"
+  "github.com/nokia/danm-utils/types/poltypes"
+)
+
+var (
+  isolatedRuleSet = poltypes.NetRuleSet {
+    IsIngressIsolated: true,
+    IngressV4Chain: poltypes.NetRuleChain{Name: poltypes.IngressV4ChainName, Rules: []poltypes.NetRule{{SourceIp: "10.0.0.1", Protocol: "tcp", DestPort: "80"}}},
+    EgressV4Chain: poltypes.NetRuleChain{Name: poltypes.EgressV4ChainName},
+  }
+  exceptRuleSet = poltypes.NetRuleSet {
+    IsIngressIsolated: true,
+    IngressV4Chain: poltypes.NetRuleChain{Name: poltypes.IngressV4ChainName, Rules: []poltypes.NetRule{{SourceIp: "192.168.0.0/16", Operation: "DANM_INGRESS_V4_EXCEPT_0"}},
+      SubChains: []poltypes.NetRuleChain{{Name: "DANM_INGRESS_V4_EXCEPT_0", Rules: []poltypes.NetRule{{SourceIp: "192.168.1.0/24", Operation: poltypes.IptablesReturn}, {}}}}},
+    EgressV4Chain: poltypes.NetRuleChain{Name: poltypes.EgressV4ChainName},
+  }
+  notIsolatedRuleSet = poltypes.NetRuleSet {
+    IngressV4Chain: poltypes.NetRuleChain{Name: poltypes.IngressV4ChainName},
+    EgressV4Chain: poltypes.NetRuleChain{Name: poltypes.EgressV4ChainName},
+  }
+)
+
+const (
+  savedHeader = "# Generated by iptables-save v1.8.4\n*filter\n:INPUT ACCEPT [0:0]\n:FORWARD ACCEPT [0:0]\n:OUTPUT ACCEPT [0:0]\n"
+  savedOwnChains = ":DANM_FORWARD - [0:0]\n:DANM_INGRESS_V4 - [0:0]\n:DANM_INPUT - [0:0]\n"
+  savedJumpRules = "-A INPUT -j DANM_INPUT\n-A FORWARD -j DANM_FORWARD\n"
+  savedInputRules = "-A DANM_INPUT -j DANM_INGRESS_V4\n-A DANM_INPUT -i lo -j ACCEPT\n-A DANM_INPUT -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT\n-A DANM_INPUT -j REJECT --reject-with icmp-port-unreachable\n"
+  savedForwardRules = "-A DANM_FORWARD -j REJECT --reject-with icmp-port-unreachable\n-A DANM_FORWARD -m comment --comment \"FINGERPRINT\"\n"
+  savedOwnRules = "-A DANM_INGRESS_V4 -s 10.0.0.1/32 -p tcp -m tcp --dport 80 -j ACCEPT\n-A DANM_INGRESS_V4 -j RETURN\n"
+  savedTable = savedHeader + savedOwnChains + savedJumpRules + savedForwardRules + savedInputRules + savedOwnRules
+  //legacyTable is the layout of earlier Policer versions, writing the default rules directly into the built-in chains
+  legacyTable = savedHeader + ":DANM_INGRESS_V4 - [0:0]\n-A INPUT -j DANM_INGRESS_V4\n-A INPUT -i lo -j ACCEPT\n-A INPUT -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT\n" +
+    "-A INPUT -i eth0 -j REJECT --reject-with icmp-port-unreachable\n-A FORWARD -j REJECT --reject-with icmp-port-unreachable\n-A FORWARD -m comment --comment \"danm-policer:0000\"\n" + savedOwnRules
+  foreignRule = "-A INPUT -p udp -m udp --dport 5000 -j ACCEPT\n"
+)
+
+var isTableProvisionedTcs = []struct {
+  tcName string
+  ruleSet *poltypes.NetRuleSet
+  savedTable string
+  isProvisioned bool
+}{
+  {"provisioned", &isolatedRuleSet, savedTable + "COMMIT\n", true},
+  {"replacedRule", &isolatedRuleSet, strings.Replace(savedTable, "--dport 80", "--dport 8080", 1) + "COMMIT\n", false},
+  {"missingRule", &isolatedRuleSet, strings.Replace(savedTable, "-A DANM_INGRESS_V4 -j RETURN\n", "", 1) + "COMMIT\n", false},
+  {"foreignRuleInBuiltinChain", &isolatedRuleSet, savedTable + foreignRule + "COMMIT\n", true},
+  {"foreignRuleInOwnChain", &isolatedRuleSet, savedTable + "-A DANM_INPUT -p udp -j ACCEPT\nCOMMIT\n", false},
+  {"missingJump", &isolatedRuleSet, strings.Replace(savedTable, "-A INPUT -j DANM_INPUT\n", "", 1) + "COMMIT\n", false},
+  {"duplicateJump", &isolatedRuleSet, savedTable + "-A INPUT -j DANM_INPUT\nCOMMIT\n", false},
+  {"staleOwnChain", &isolatedRuleSet, savedTable + ":DANM_EGRESS_V4 - [0:0]\nCOMMIT\n", false},
+  {"otherFingerprint", &isolatedRuleSet, strings.Replace(savedTable, "FINGERPRINT", poltypes.FingerprintPrefix + "0000", 1) + "COMMIT\n", false},
+  {"withoutFingerprint", &isolatedRuleSet, strings.Replace(savedTable, "-A DANM_FORWARD -m comment --comment \"FINGERPRINT\"\n", "", 1) + "COMMIT\n", false},
+  {"legacyLayout", &isolatedRuleSet, legacyTable + "COMMIT\n", false},
+  {"notIsolated", &notIsolatedRuleSet, savedHeader + foreignRule + "COMMIT\n", true},
+  {"notIsolatedWithOwnChain", &notIsolatedRuleSet, savedHeader + ":DANM_INGRESS_V4 - [0:0]\nCOMMIT\n", false},
+  {"notIsolatedWithJump", &notIsolatedRuleSet, savedHeader + "-A INPUT -j DANM_INPUT\nCOMMIT\n", false},
+  {"notIsolatedWithFingerprint", &notIsolatedRuleSet, savedHeader + "-A FORWARD -m comment --comment \"danm-policer:0000\"\nCOMMIT\n", false},
+  {"notIsolatedWithLegacyLayout", &notIsolatedRuleSet, legacyTable + "COMMIT\n", false},
+  {"notIsolatedWithLegacyDefaultRules", &notIsolatedRuleSet, savedHeader + "-A INPUT -i lo -j ACCEPT\n-A INPUT -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT\n" +
+    "-A INPUT -j REJECT --reject-with icmp-port-unreachable\nCOMMIT\n", false},
+}
+
+func TestIsTableProvisioned(t *testing.T) {
+  for _, tc := range isTableProvisionedTcs {
+    t.Run(tc.tcName, func(t *testing.T) {
+      chains := renderOwnChains(tc.ruleSet, tc.ruleSet.IngressV4Chain, tc.ruleSet.EgressV4Chain)
+      fingerprint := ""
+      if len(chains) > 0 {
+        fingerprint = poltypes.FindFingerprint([]byte(strings.Join(chains[len(chains)-1].Rules, "\n")))
+      }
+      savedTable := strings.Replace(tc.savedTable, "FINGERPRINT", fingerprint, 1)
+      isProvisioned := isTableProvisioned(chains, parseTable([]byte(savedTable)))
+      if isProvisioned != tc.isProvisioned {
+        t.Errorf("Expected provisioned:%t for saved table:%s and chains:%v, but we got:%t", tc.isProvisioned, savedTable, chains, isProvisioned)
+      }
+    })
+  }
+}
+
+var renderPayloadTcs = []struct {
+  tcName string
+  ruleSet *poltypes.NetRuleSet
+  savedTable string
+  expectedLines []string
+  unexpectedLines []string
+}{
+  {"newPod", &isolatedRuleSet, savedHeader + foreignRule + "COMMIT\n",
+    []string{":DANM_INPUT - [0:0]", "-A DANM_INPUT -j DANM_INGRESS_V4", "-I INPUT 1 -j DANM_INPUT", "-I FORWARD 1 -j DANM_FORWARD"},
+    []string{":INPUT ACCEPT [0:0]", "-F INPUT", "-D INPUT -p udp -m udp --dport 5000 -j ACCEPT", "-I OUTPUT 1 -j DANM_OUTPUT"}},
+  {"provisionedPod", &isolatedRuleSet, savedTable + foreignRule + "COMMIT\n",
+    []string{":DANM_INGRESS_V4 - [0:0]", "-A DANM_INGRESS_V4 -p tcp --dport 80 -s 10.0.0.1 -j ACCEPT"},
+    []string{"-I INPUT 1 -j DANM_INPUT", "-D INPUT -j DANM_INPUT", "-D INPUT -p udp -m udp --dport 5000 -j ACCEPT", "-X DANM_INGRESS_V4"}},
+  {"legacyLayout", &isolatedRuleSet, legacyTable + foreignRule + "COMMIT\n",
+    []string{"-D INPUT -j DANM_INGRESS_V4", "-D INPUT -i lo -j ACCEPT", "-D INPUT -i eth0 -j REJECT --reject-with icmp-port-unreachable",
+      "-D FORWARD -j REJECT --reject-with icmp-port-unreachable", "-D FORWARD -m comment --comment \"danm-policer:0000\"", "-I INPUT 1 -j DANM_INPUT"},
+    []string{"-D INPUT -p udp -m udp --dport 5000 -j ACCEPT", "-X DANM_INGRESS_V4"}},
+  {"exceptSubChain", &exceptRuleSet, savedTable + "COMMIT\n",
+    []string{":DANM_INGRESS_V4_EXCEPT_0 - [0:0]", "-A DANM_INGRESS_V4 -s 192.168.0.0/16 -j DANM_INGRESS_V4_EXCEPT_0", "-A DANM_INGRESS_V4_EXCEPT_0 -s 192.168.1.0/24 -j RETURN", "-A DANM_INGRESS_V4_EXCEPT_0 -j ACCEPT"},
+    []string{"-X DANM_INGRESS_V4_EXCEPT_0"}},
+  {"staleSubChain", &isolatedRuleSet, savedTable + ":DANM_INGRESS_V4_EXCEPT_0 - [0:0]\n-A DANM_INGRESS_V4_EXCEPT_0 -j ACCEPT\nCOMMIT\n",
+    []string{":DANM_INGRESS_V4_EXCEPT_0 - [0:0]", "-X DANM_INGRESS_V4_EXCEPT_0"},
+    []string{"-A DANM_INGRESS_V4_EXCEPT_0 -j ACCEPT"}},
+  {"unisolatedPod", &notIsolatedRuleSet, savedTable + foreignRule + "COMMIT\n",
+    []string{":DANM_INPUT - [0:0]", "-D INPUT -j DANM_INPUT", "-D FORWARD -j DANM_FORWARD", "-X DANM_INPUT", "-X DANM_INGRESS_V4", "-X DANM_FORWARD"},
+    []string{"-D INPUT -p udp -m udp --dport 5000 -j ACCEPT", "-A DANM_INPUT -i lo -j ACCEPT"}},
+  {"foreignDefaultLikeRules", &notIsolatedRuleSet, savedHeader + "-A INPUT -i lo -j ACCEPT\n-A INPUT -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT\nCOMMIT\n",
+    []string{"COMMIT"},
+    []string{"-D INPUT -i lo -j ACCEPT", "-D INPUT -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT"}},
+}
+
+func TestRenderPayload(t *testing.T) {
+  for _, tc := range renderPayloadTcs {
+    t.Run(tc.tcName, func(t *testing.T) {
+      chains := renderOwnChains(tc.ruleSet, tc.ruleSet.IngressV4Chain, tc.ruleSet.EgressV4Chain)
+      payload := string(renderPayload(chains, parseTable([]byte(tc.savedTable))))
+      payloadLines := make(map[string]bool)
+      for _, line := range strings.Split(payload, "\n") {
+        payloadLines[line] = true
+      }
+      for _, line := range tc.expectedLines {
+        if !payloadLines[line] {
+          t.Errorf("Expected line:%s is missing from payload:\n%s", line, payload)
+        }
+      }
+      for _, line := range tc.unexpectedLines {
+        if payloadLines[line] {
+          t.Errorf("Unexpected line:%s is present in payload:\n%s", line, payload)
+        }
+      }
+    })
+  }
+}
\ No newline at end of file
@@ -0,0 +1,459 @@
+package nftables
+
+import (
+  "bytes"
+  "errors"
+  "math/big"
+  "net"
+  "reflect"
+  "sort"
+  "strconv"
+  "strings"
+  "github.com/nokia/danm-utils/pkg/provisioner/podns"
+  "github.com/nokia/danm-utils/types/poltypes"
+  corev1 "k8s.io/api/core/v1"
+  "k8s.io/utils/exec"
+)
+
+const (
+  NftBinary = "nft"
+  TableFamily = "inet"
+  TableName = "danm"
+  InputChainName = "input"
+  OutputChainName = "output"
+  ForwardChainName = "forward"
+  V4Family = "ip"
+  V6Family = "ip6"
+  NftRangeSeparator = "-"
+)
+
+var (
+  DefaultInputRules = poltypes.NetRuleChain {
+    Name: InputChainName, Rules: poltypes.DefaultIngressRules,
+  }
+  DefaultOutputRules = poltypes.NetRuleChain {
+    Name: OutputChainName, Rules: poltypes.DefaultEgressRules,
+  }
+  DefaultForwardRules = poltypes.NetRuleChain {
+    Name: ForwardChainName, Rules: poltypes.DefaultForwardRules,
+  }
+  DefaultReturnRule = poltypes.NetRule {
+    Operation: poltypes.IptablesReturn,
+  }
+)
+
+type NftablesProvisioner struct {
+  Exec exec.Interface
+}
+
+//familyChain is a Policer created chain, only containing rules of one IP family
+type familyChain struct {
+  Chain   poltypes.NetRuleChain
+  Family  string
+  NfProto string
+}
+
+//ruleGroup is a list of consecutive rules of a chain only differing in their peer addresses
+//Every group is provisioned as exactly one nftables rule, matching on a named set containing all the addresses
+type ruleGroup struct {
+  Rule      poltypes.NetRule
+  Addresses []string
+  IsSource  bool
+  SetName   string
+}
+
+func NewNftablesProvisioner() *NftablesProvisioner {
+  return &NftablesProvisioner{Exec: exec.New()}
+}
+
+//AddRulesToPod replaces the content of the Pod's dedicated danm table with the one rendered from the NetRuleSet
+//The old table is deleted and the new one is created in the same nft transaction, so the policy of the Pod flips atomically
+func (nftProv *NftablesProvisioner) AddRulesToPod(ruleSet *poltypes.NetRuleSet, pod *corev1.Pod) error {
+  payload := renderPayload(ruleSet, ruleSet.IsIngressIsolated || ruleSet.IsEgressIsolated)
+  return podns.Execute(ruleSet.Netns, func() error {
+    return nftProv.restorePayload(payload)
+  })
+}
+
+//RemoveRulesFromPod takes away all isolation from a Pod which is not selected by any network policies anymore
+//Only the Netns of the provided RuleSet is used, the whole danm table is removed from the Pod regardless of the content of the RuleSet
+func (nftProv *NftablesProvisioner) RemoveRulesFromPod(ruleSet *poltypes.NetRuleSet, pod *corev1.Pod) error {
+  payload := renderPayload(ruleSet, false)
+  return podns.Execute(ruleSet.Netns, func() error {
+    return nftProv.restorePayload(payload)
+  })
+}
+
+//IsRuleSetProvisioned compares the danm table listed from the Pod with the script AddRulesToPod would run
+func (nftProv *NftablesProvisioner) IsRuleSetProvisioned(ruleSet *poltypes.NetRuleSet, pod *corev1.Pod) (bool, error) {
+  payload := renderPayload(ruleSet, ruleSet.IsIngressIsolated || ruleSet.IsEgressIsolated)
+  var table []byte
+  err := podns.Execute(ruleSet.Netns, func() error {
+    var err error
+    table, err = nftProv.listTable()
+    return err
+  })
+  if err != nil {
+    return false, err
+  }
+  return isPayloadProvisioned(payload, table), nil
+}
+
+//listTable returns the danm table of the Pod as listed by nft, or nil when the Pod does not have one
+func (nftProv *NftablesProvisioner) listTable() ([]byte, error) {
+  out, err := nftProv.Exec.Command(NftBinary, "list", "tables").CombinedOutput()
+  if err != nil {
+    return nil, errors.New("nft failed with error:" + err.Error() + " and output:" + string(out))
+  }
+  if !hasTable(out) {
+    return nil, nil
+  }
+  out, err = nftProv.Exec.Command(NftBinary, "list", "table", TableFamily, TableName).CombinedOutput()
+  if err != nil {
+    return nil, errors.New("nft failed with error:" + err.Error() + " and output:" + string(out))
+  }
+  return out, nil
+}
+
+func hasTable(tables []byte) bool {
+  for _, line := range strings.Split(string(tables), "\n") {
+    if strings.TrimSpace(line) == "table " + TableFamily + " " + TableName {
+      return true
+    }
+  }
+  return false
+}
+
+//isPayloadProvisioned compares the danm table listed from the Pod with a rendered script
+//Isolated Pods need to carry the fingerprint of the script, and need to have exactly the same chains with the same rules, and the same sets with the same addresses as the script has
+//Pods without isolation must not have a danm table at all
+func isPayloadProvisioned(payload, table []byte) bool {
+  fingerprint := poltypes.FindFingerprint(payload)
+  if fingerprint == "" {
+    return table == nil
+  }
+  if table == nil || poltypes.FindFingerprint(table) != fingerprint {
+    return false
+  }
+  return reflect.DeepEqual(listRules(payload), listRules(table)) && reflect.DeepEqual(listSets(payload), listSets(table))
+}
+
+//listRules returns the normalized rules of each chain of an nft script, or an nft listing
+//Sets are skipped, and so are the hook declarations of base chains
+func listRules(table []byte) map[string][]string {
+  rules := make(map[string][]string)
+  chainName := ""
+  for _, line := range strings.Split(string(table), "\n") {
+    line = strings.TrimSpace(line)
+    fields := strings.Fields(line)
+    if len(fields) == 3 && fields[0] == "chain" && fields[2] == "{" {
+      chainName = fields[1]
+      rules[chainName] = make([]string, 0)
+      continue
+    }
+    if chainName == "" || line == "" || strings.HasPrefix(line, "type ") {
+      continue
+    }
+    if line == "}" {
+      chainName = ""
+      continue
+    }
+    rules[chainName] = append(rules[chainName], normalizeRule(fields))
+  }
+  return rules
+}
+
+//listSets returns the normalized address ranges of each set of an nft script, or an nft listing
+//nft lists the elements of bigger sets in multiple lines, so the elements are collected until the closing bracket
+func listSets(table []byte) map[string][]string {
+  sets := make(map[string][]string)
+  setName, elements := "", ""
+  isInElements := false
+  for _, line := range strings.Split(string(table), "\n") {
+    line = strings.TrimSpace(line)
+    fields := strings.Fields(line)
+    if len(fields) == 3 && fields[0] == "set" && fields[2] == "{" {
+      setName = fields[1]
+      sets[setName] = make([]string, 0)
+      continue
+    }
+    if setName == "" {
+      continue
+    }
+    if strings.HasPrefix(line, "elements = {") {
+      isInElements, elements = true, ""
+      line = strings.TrimPrefix(line, "elements = {")
+    }
+    if !isInElements {
+      if line == "}" {
+        setName = ""
+      }
+      continue
+    }
+    if strings.HasSuffix(line, "}") {
+      isInElements = false
+      line = strings.TrimSuffix(line, "}")
+      sets[setName] = normalizeElements(strings.Split(elements + line, ","))
+    }
+    elements += line
+  }
+  return sets
+}
+
+//normalizeElements converts the elements of a set into sorted, non-overlapping address ranges
+//nft lists host addresses without prefix, and merges the overlapping, or adjacent elements of auto-merge sets, so the elements can't be compared as they are written
+//Elements which can't be parsed are kept as they are, so they never match a valid range
+func normalizeElements(elements []string) []string {
+  type addressRange struct {
+    First, Last *big.Int
+  }
+  ranges := make([]addressRange, 0)
+  invalidElements := make([]string, 0)
+  for _, element := range elements {
+    element = strings.TrimSpace(element)
+    if element == "" {
+      continue
+    }
+    var first, last net.IP
+    if bounds := strings.Split(element, NftRangeSeparator); len(bounds) == 2 {
+      first, last = net.ParseIP(strings.TrimSpace(bounds[0])), net.ParseIP(strings.TrimSpace(bounds[1]))
+    } else if _, cidrNet, err := net.ParseCIDR(element); err == nil {
+      first, last = cidrNet.IP, make(net.IP, len(cidrNet.IP))
+      for i := range cidrNet.IP {
+        last[i] = cidrNet.IP[i] | ^cidrNet.Mask[i]
+      }
+    } else {
+      first = net.ParseIP(element)
+      last = first
+    }
+    if first == nil || last == nil {
+      invalidElements = append(invalidElements, element)
+      continue
+    }
+    ranges = append(ranges, addressRange{First: new(big.Int).SetBytes(first.To16()), Last: new(big.Int).SetBytes(last.To16())})
+  }
+  sort.Slice(ranges, func(i, j int) bool {
+    return ranges[i].First.Cmp(ranges[j].First) < 0
+  })
+  normalizedElements := make([]string, 0)
+  for i := 0; i < len(ranges); i++ {
+    first, last := ranges[i].First, ranges[i].Last
+    for i+1 < len(ranges) && ranges[i+1].First.Cmp(new(big.Int).Add(last, big.NewInt(1))) <= 0 {
+      i++
+      if ranges[i].Last.Cmp(last) > 0 {
+        last = ranges[i].Last
+      }
+    }
+    normalizedElements = append(normalizedElements, first.String() + NftRangeSeparator + last.String())
+  }
+  sort.Strings(invalidElements)
+  return append(normalizedElements, invalidElements...)
+}
+
+//normalizeRule evens out the differences between a rule of an nft script, and the same rule listed by nft
+//nft lists the values of the counters, and orders the connection states by their value
+func normalizeRule(fields []string) string {
+  normalizedFields := make([]string, 0)
+  for i := 0; i < len(fields); i++ {
+    normalizedFields = append(normalizedFields, fields[i])
+    if fields[i] == "counter" && i+4 < len(fields) && fields[i+1] == "packets" && fields[i+3] == "bytes" {
+      i += 4
+    } else if fields[i] == "state" && i+1 < len(fields) {
+      states := strings.Split(fields[i+1], ",")
+      sort.Strings(states)
+      normalizedFields = append(normalizedFields, strings.Join(states, ","))
+      i++
+    }
+  }
+  return strings.Join(normalizedFields, " ")
+}
+
+func (nftProv *NftablesProvisioner) restorePayload(payload []byte) error {
+  cmd := nftProv.Exec.Command(NftBinary, "-f", "-")
+  cmd.SetStdin(bytes.NewReader(payload))
+  out, err := cmd.CombinedOutput()
+  if err != nil {
+    return errors.New("nft failed with error:" + err.Error() + " and output:" + string(out))
+  }
+  return nil
+}
+
+//renderPayload creates an nft script atomically replacing the danm table of a Pod
+//Base chains are only created for the isolated directions. When the Pod is not isolated at all the script only removes the table
+func renderPayload(ruleSet *poltypes.NetRuleSet, isIsolated bool) []byte {
+  var payload bytes.Buffer
+  tableId := TableFamily + " " + TableName
+  //Declaring the table before deleting it makes the script work regardless whether the table existed before
+  payload.WriteString("table " + tableId + "\n")
+  payload.WriteString("delete table " + tableId + "\n")
+  if !isIsolated {
+    return payload.Bytes()
+  }
+  var chains bytes.Buffer
+  payload.WriteString("table " + tableId + " {\n")
+  //Own chains of directions which are not isolated are left empty, so they are not provisioned at all
+  ingressChains := make([]familyChain, 0)
+  if ruleSet.IsIngressIsolated {
+    ingressChains = append(ingressChains, newV4Chain(ruleSet.IngressV4Chain), newV6Chain(ruleSet.IngressV6Chain))
+  }
+  egressChains := make([]familyChain, 0)
+  if ruleSet.IsEgressIsolated {
+    egressChains = append(egressChains, newV4Chain(ruleSet.EgressV4Chain), newV6Chain(ruleSet.EgressV6Chain))
+  }
+  for _, ownChain := range append(ingressChains, egressChains...) {
+    if len(ownChain.Chain.Rules) == 0 {
+      continue
+    }
+    //Sub-chains need to be declared before the chains jumping to them. They are left at their end, continuing the evaluation of the own chain
+    for _, subChain := range ownChain.Chain.SubChains {
+      writeChain(&payload, &chains, familyChain{Chain: subChain, Family: ownChain.Family, NfProto: ownChain.NfProto}, false)
+    }
+    //We need to add a default "return" rule to the end of our own chains
+    writeChain(&payload, &chains, ownChain, true)
+  }
+  payload.Write(chains.Bytes())
+  if ruleSet.IsIngressIsolated {
+    writeBaseChain(&payload, DefaultInputRules, ingressChains, ruleSet.IngressIfaces, true, "")
+  }
+  if ruleSet.IsEgressIsolated {
+    writeBaseChain(&payload, DefaultOutputRules, egressChains, ruleSet.EgressIfaces, false, "")
+  }
+  writeBaseChain(&payload, DefaultForwardRules, nil, nil, false, poltypes.NewFingerprint(payload.Bytes()))
+  payload.WriteString("}\n")
+  return payload.Bytes()
+}
+
+//writeChain writes a regular chain into the chains, and the sets referenced by its rules into the payload
+//Sets need to be declared before the chains referencing them
+func writeChain(payload, chains *bytes.Buffer, ownChain familyChain, isReturnNeeded bool) {
+  chains.WriteString("  chain " + ownChain.Chain.Name + " {\n")
+  for _, group := range groupRules(ownChain.Chain) {
+    if group.SetName != "" {
+      writeSet(payload, group, ownChain.Family)
+    }
+    writeRule(chains, group, ownChain.Family)
+  }
+  if isReturnNeeded {
+    writeRule(chains, ruleGroup{Rule: DefaultReturnRule}, ownChain.Family)
+  }
+  chains.WriteString("  }\n")
+}
+
+func newV4Chain(chain poltypes.NetRuleChain) familyChain {
+  return familyChain{Chain: chain, Family: V4Family, NfProto: "ipv4"}
+}
+
+func newV6Chain(chain poltypes.NetRuleChain) familyChain {
+  return familyChain{Chain: chain, Family: V6Family, NfProto: "ipv6"}
+}
+
+//groupRules squashes consecutive rules only differing in their peer address into one group
+//Only consecutive rules are squashed so the evaluation order of the original chain is kept intact
+func groupRules(chain poltypes.NetRuleChain) []ruleGroup {
+  groups := make([]ruleGroup, 0)
+  for _, rule := range chain.Rules {
+    address, isSource := rule.SourceIp, true
+    if address == "" {
+      address, isSource = rule.DestIp, false
+    }
+    rule.SourceIp, rule.DestIp = "", ""
+    lastIndex := len(groups)-1
+    if address != "" && lastIndex >= 0 && groups[lastIndex].SetName != "" &&
+       groups[lastIndex].Rule == rule && groups[lastIndex].IsSource == isSource {
+      groups[lastIndex].Addresses = appendWithoutDupes(groups[lastIndex].Addresses, address)
+      continue
+    }
+    group := ruleGroup{Rule: rule, IsSource: isSource}
+    if address != "" {
+      group.Addresses = []string{address}
+      group.SetName = chain.Name + "_" + strconv.Itoa(len(groups))
+    }
+    groups = append(groups, group)
+  }
+  return groups
+}
+
+func appendWithoutDupes(addresses []string, address string) []string {
+  for _, existingAddress := range addresses {
+    if existingAddress == address {
+      return addresses
+    }
+  }
+  return append(addresses, address)
+}
+
+func writeSet(payload *bytes.Buffer, group ruleGroup, family string) {
+  addressType := "ipv4_addr"
+  if family == V6Family {
+    addressType = "ipv6_addr"
+  }
+  //Interval flag allows CIDRs as elements, auto-merge allows them to overlap
+  payload.WriteString("  set " + group.SetName + " {\n")
+  payload.WriteString("    type " + addressType + "; flags interval; auto-merge;\n")
+  payload.WriteString("    elements = { " + strings.Join(group.Addresses, ", ") + " }\n")
+  payload.WriteString("  }\n")
+}
+
+//writeBaseChain creates a base chain with the jump rules towards the own chains, and the default rules
+//Jump and REJECT rules only match the isolated interfaces of the Pod
+//The marker rule carrying the fingerprint is never reached after the reject rule, it only identifies the script provisioned into the Pod
+func writeBaseChain(payload *bytes.Buffer, defaultRules poltypes.NetRuleChain, ownChains []familyChain, ifaces []string, isIngress bool, fingerprint string) {
+  payload.WriteString("  chain " + defaultRules.Name + " {\n")
+  payload.WriteString("    type filter hook " + defaultRules.Name + " priority 0; policy accept;\n")
+  for _, ownChain := range ownChains {
+    if len(ownChain.Chain.Rules) == 0 {
+      continue
+    }
+    for _, jumpRule := range poltypes.RestrictToIfaces(poltypes.NetRule{Operation: ownChain.Chain.Name}, ifaces, isIngress) {
+      //Own chains are IP family specific just like in the iptables backend, even though the inet table sees both families
+      payload.WriteString("    meta nfproto " + ownChain.NfProto + " " + strings.Join(createExprFromRule(ruleGroup{Rule: jumpRule}, ""), " ") + "\n")
+    }
+  }
+  for _, rule := range poltypes.RestrictDefaultRules(defaultRules.Rules, ifaces, isIngress) {
+    writeRule(payload, ruleGroup{Rule: rule}, "")
+  }
+  if fingerprint != "" {
+    payload.WriteString("    counter comment " + strconv.Quote(fingerprint) + "\n")
+  }
+  payload.WriteString("  }\n")
+}
+
+func writeRule(payload *bytes.Buffer, group ruleGroup, family string) {
+  payload.WriteString("    " + strings.Join(createExprFromRule(group, family), " ") + "\n")
+}
+
+func createExprFromRule(group ruleGroup, family string) []string {
+  rule := group.Rule
+  expr := make([]string, 0)
+  if rule.SourceIface != "" {expr = append(expr, "iifname", strconv.Quote(rule.SourceIface))}
+  if rule.DestIface   != "" {expr = append(expr, "oifname", strconv.Quote(rule.DestIface))}
+  if group.SetName != "" {
+    direction := "daddr"
+    if group.IsSource {
+      direction = "saddr"
+    }
+    expr = append(expr, family, direction, "@" + group.SetName)
+  }
+  if rule.Protocol != "" {
+    if rule.SourcePort == "" && rule.DestPort == "" {expr = append(expr, "meta", "l4proto", rule.Protocol)}
+    if rule.SourcePort != "" {expr = append(expr, rule.Protocol, "sport", toNftPort(rule.SourcePort))}
+    if rule.DestPort   != "" {expr = append(expr, rule.Protocol, "dport", toNftPort(rule.DestPort))}
+  }
+  if rule.State != "" {expr = append(expr, "ct", "state", strings.ToLower(rule.State))}
+  switch rule.Operation {
+  case "", poltypes.IptablesAccept:
+    expr = append(expr, "accept")
+  case poltypes.IptablesReject:
+    expr = append(expr, "reject")
+  case poltypes.IptablesReturn:
+    expr = append(expr, "return")
+  default:
+    expr = append(expr, "jump", rule.Operation)
+  }
+  return expr
+}
+
+//toNftPort converts port ranges to the nft range syntax
+func toNftPort(port string) string {
+  return strings.Replace(port, poltypes.PortRangeSeparator, NftRangeSeparator, 1)
+}
\ No newline at end of file
@@ -0,0 +1,68 @@
+package nftables
+
+import (
+  "strings"
+  "testing"
+  "github.com/nokia/danm-utils/types/poltypes"
+)
+
+var (
+  isolatedRuleSet = poltypes.NetRuleSet {
+    IsIngressIsolated: true,
+    IngressV4Chain: poltypes.NetRuleChain{Name: poltypes.IngressV4ChainName, Rules: []poltypes.NetRule {
+      {SourceIp: "10.0.0.1", Protocol: "tcp", DestPort: "80"},
+      {SourceIp: "192.168.0.0/16", Protocol: "tcp", DestPort: "80"},
+      {SourceIp: "192.168.1.0/24", Protocol: "tcp", DestPort: "80"},
+    }},
+    IngressV6Chain: poltypes.NetRuleChain{Name: poltypes.IngressV6ChainName},
+  }
+)
+
+const (
+  listedSet = "\tset DANM_INGRESS_V4_0 {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t\tauto-merge\n\t\telements = { 10.0.0.1,\n\t\t\t     192.168.0.0/16 }\n\t}\n\n"
+  listedOwnChain = "\tchain DANM_INGRESS_V4 {\n\t\tip saddr @DANM_INGRESS_V4_0 tcp dport 80 accept\n\t\treturn\n\t}\n\n"
+  listedInputChain = "\tchain input {\n\t\ttype filter hook input priority filter; policy accept;\n\t\tmeta nfproto ipv4 jump DANM_INGRESS_V4\n\t\tiifname \"lo\" accept\n\t\tct state established,related accept\n\t\treject\n\t}\n\n"
+  listedForwardChain = "\tchain forward {\n\t\ttype filter hook forward priority filter; policy accept;\n\t\treject\n\t\tcounter packets 0 bytes 0 comment \"FINGERPRINT\"\n\t}\n"
+)
+
+var isPayloadProvisionedTcs = []struct {
+  tcName string
+  ruleSet *poltypes.NetRuleSet
+  table *string
+  isProvisioned bool
+}{
+  {"provisioned", &isolatedRuleSet, newTable(listedSet + listedOwnChain + listedInputChain + listedForwardChain), true},
+  {"countedPackets", &isolatedRuleSet, newTable(listedSet + listedOwnChain + listedInputChain + strings.Replace(listedForwardChain, "packets 0 bytes 0", "packets 12 bytes 720", 1)), true},
+  {"missingTable", &isolatedRuleSet, nil, false},
+  {"mergedSetElements", &isolatedRuleSet, newTable(strings.Replace(listedSet, "10.0.0.1,\n\t\t\t     192.168.0.0/16", "192.168.0.0-192.168.255.255, 10.0.0.1/32", 1) + listedOwnChain + listedInputChain + listedForwardChain), true},
+  {"removedSetElement", &isolatedRuleSet, newTable(strings.Replace(listedSet, "10.0.0.1,\n\t\t\t     ", "", 1) + listedOwnChain + listedInputChain + listedForwardChain), false},
+  {"shrunkSetElement", &isolatedRuleSet, newTable(strings.Replace(listedSet, "192.168.0.0/16", "192.168.0.0/24", 1) + listedOwnChain + listedInputChain + listedForwardChain), false},
+  {"missingSet", &isolatedRuleSet, newTable(listedOwnChain + listedInputChain + listedForwardChain), false},
+  {"replacedRule", &isolatedRuleSet, newTable(listedSet + strings.Replace(listedOwnChain, "dport 80", "dport 8080", 1) + listedInputChain + listedForwardChain), false},
+  {"missingRule", &isolatedRuleSet, newTable(listedSet + strings.Replace(listedOwnChain, "\t\treturn\n", "", 1) + listedInputChain + listedForwardChain), false},
+  {"extraChain", &isolatedRuleSet, newTable(listedSet + listedOwnChain + strings.Replace(listedOwnChain, "V4 {", "V6 {", 1) + listedInputChain + listedForwardChain), false},
+  {"otherFingerprint", &isolatedRuleSet, newTable(listedSet + listedOwnChain + listedInputChain + strings.Replace(listedForwardChain, "FINGERPRINT", poltypes.FingerprintPrefix + "0000", 1)), false},
+  {"notIsolated", &poltypes.NetRuleSet{}, nil, true},
+  {"notIsolatedWithTable", &poltypes.NetRuleSet{}, newTable(listedSet + listedOwnChain + listedInputChain + listedForwardChain), false},
+}
+
+func TestIsPayloadProvisioned(t *testing.T) {
+  for _, tc := range isPayloadProvisionedTcs {
+    t.Run(tc.tcName, func(t *testing.T) {
+      payload := renderPayload(tc.ruleSet, tc.ruleSet.IsIngressIsolated || tc.ruleSet.IsEgressIsolated)
+      var table []byte
+      if tc.table != nil {
+        table = []byte(strings.Replace(*tc.table, "FINGERPRINT", poltypes.FindFingerprint(payload), 1))
+      }
+      isProvisioned := isPayloadProvisioned(payload, table)
+      if isProvisioned != tc.isProvisioned {
+        t.Errorf("Expected provisioned:%t for listed table:%s and payload:%s, but we got:%t", tc.isProvisioned, table, payload, isProvisioned)
+      }
+    })
+  }
+}
+
+func newTable(chains string) *string {
+  table := "table inet danm {\n" + chains + "}\n"
+  return &table
+}
\ No newline at end of file
@@ -0,0 +1,31 @@
+package podns
+
+import (
+  "errors"
+  "runtime"
+  "github.com/containernetworking/plugins/pkg/ns"
+)
+
+//Execute runs the provided function from within the network namespace of a Pod
+//The OS thread is locked for the whole duration, so all child processes started by the function also inherit the Pod's netns
+func Execute(netns string, provisionerFunc func() error) error {
+  runtime.LockOSThread()
+  defer runtime.UnlockOSThread()
+  origns, err := ns.GetCurrentNS()
+  if err != nil {
+    return errors.New("failed to get the current netns because:" + err.Error())
+  }
+  hns, err := ns.GetNS(netns)
+  if err != nil {
+    return errors.New("failed to get into Pod's netns:" + netns + " cause of error:" + err.Error())
+  }
+  defer func() {
+    hns.Close()
+    origns.Set()
+  }()
+  err = hns.Set()
+  if err != nil {
+    return errors.New("failed to enter network namespace:" + netns + " because of error:"+ err.Error())
+  }
+  return provisionerFunc()
+}
\ No newline at end of file
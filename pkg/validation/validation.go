@@ -0,0 +1,203 @@
+package validation
+
+import (
+  "context"
+  "net"
+  danmclientset "github.com/nokia/danm/crd/client/clientset/versioned"
+  polv1 "github.com/nokia/danm-utils/crd/api/netpol/v1"
+  "github.com/nokia/danm-utils/types/poltypes"
+  corev1 "k8s.io/api/core/v1"
+  apierrors "k8s.io/apimachinery/pkg/api/errors"
+  metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+  "k8s.io/apimachinery/pkg/util/intstr"
+  "k8s.io/apimachinery/pkg/util/validation/field"
+  "k8s.io/kubernetes/pkg/apis/networking"
+)
+
+const (
+  MinPort = 1
+  MaxPort = 65535
+)
+
+//ValidateNetPolSpec checks whether all the selectors, peers, and ports of a policy can be understood by the Policer
+//It returns all the problems found, so the user can fix them at once
+func ValidateNetPolSpec(spec polv1.NetPolSpec, specPath *field.Path) field.ErrorList {
+  allErrs := field.ErrorList{}
+  allErrs = append(allErrs, validateLabelSelector(&spec.PodSelector, specPath.Child("podSelector"))...)
+  for i, ingressRule := range spec.Ingress {
+    rulePath := specPath.Child("ingress").Index(i)
+    allErrs = append(allErrs, validateAction(ingressRule.Action, rulePath.Child("action"))...)
+    allErrs = append(allErrs, validatePorts(ingressRule.Ports, rulePath.Child("ports"))...)
+    allErrs = append(allErrs, validatePeers(ingressRule.From, rulePath.Child("from"))...)
+  }
+  for i, egressRule := range spec.Egress {
+    rulePath := specPath.Child("egress").Index(i)
+    allErrs = append(allErrs, validateAction(egressRule.Action, rulePath.Child("action"))...)
+    allErrs = append(allErrs, validatePorts(egressRule.Ports, rulePath.Child("ports"))...)
+    allErrs = append(allErrs, validatePeers(egressRule.To, rulePath.Child("to"))...)
+  }
+  for i, policyType := range spec.PolicyTypes {
+    if policyType != networking.PolicyTypeIngress && policyType != networking.PolicyTypeEgress {
+      allErrs = append(allErrs, field.NotSupported(specPath.Child("policyTypes").Index(i), policyType,
+        []string{string(networking.PolicyTypeIngress), string(networking.PolicyTypeEgress)}))
+    }
+  }
+  allErrs = append(allErrs, validateNetworkSelectors(spec.TargetNetworkSelector, specPath.Child("targetNetworkSelector"))...)
+  return allErrs
+}
+
+func validateLabelSelector(selector *metav1.LabelSelector, selectorPath *field.Path) field.ErrorList {
+  allErrs := field.ErrorList{}
+  if selector == nil {
+    return allErrs
+  }
+  _, err := metav1.LabelSelectorAsSelector(selector)
+  if err != nil {
+    allErrs = append(allErrs, field.Invalid(selectorPath, metav1.FormatLabelSelector(selector), err.Error()))
+  }
+  return allErrs
+}
+
+func validateAction(action polv1.RuleAction, actionPath *field.Path) field.ErrorList {
+  allErrs := field.ErrorList{}
+  if action != "" && action != polv1.RuleActionAllow && action != polv1.RuleActionDeny {
+    allErrs = append(allErrs, field.NotSupported(actionPath, action, []string{string(polv1.RuleActionAllow), string(polv1.RuleActionDeny)}))
+  }
+  return allErrs
+}
+
+func validatePorts(ports []polv1.NetworkPolicyPort, portsPath *field.Path) field.ErrorList {
+  allErrs := field.ErrorList{}
+  for i, port := range ports {
+    portPath := portsPath.Index(i)
+    if port.Protocol != nil && *port.Protocol != corev1.ProtocolTCP && *port.Protocol != corev1.ProtocolUDP && *port.Protocol != corev1.ProtocolSCTP {
+      allErrs = append(allErrs, field.NotSupported(portPath.Child("protocol"), *port.Protocol,
+        []string{string(corev1.ProtocolTCP), string(corev1.ProtocolUDP), string(corev1.ProtocolSCTP)}))
+    }
+    if port.Port != nil && port.Port.Type == intstr.Int && (port.Port.IntVal < MinPort || port.Port.IntVal > MaxPort) {
+      allErrs = append(allErrs, field.Invalid(portPath.Child("port"), port.Port.IntVal, "must be between 1 and 65535, inclusive"))
+    }
+    if port.Port != nil && port.Port.Type == intstr.String && port.Port.StrVal == "" {
+      allErrs = append(allErrs, field.Invalid(portPath.Child("port"), port.Port.StrVal, "named port can't be empty"))
+    }
+    if port.EndPort == nil {
+      continue
+    }
+    if port.Port == nil || port.Port.Type != intstr.Int {
+      allErrs = append(allErrs, field.Invalid(portPath.Child("endPort"), *port.EndPort, "can only be used together with a numeric port"))
+    } else if *port.EndPort < port.Port.IntVal || *port.EndPort > MaxPort {
+      allErrs = append(allErrs, field.Invalid(portPath.Child("endPort"), *port.EndPort, "must be between port and 65535, inclusive"))
+    }
+  }
+  return allErrs
+}
+
+func validatePeers(peers []polv1.NetworkPolicyPeer, peersPath *field.Path) field.ErrorList {
+  allErrs := field.ErrorList{}
+  for i, peer := range peers {
+    peerPath := peersPath.Index(i)
+    allErrs = append(allErrs, validateLabelSelector(&peer.PodSelector, peerPath.Child("podSelector"))...)
+    allErrs = append(allErrs, validateLabelSelector(peer.NamespaceSelector, peerPath.Child("namespaceSelector"))...)
+    allErrs = append(allErrs, validateNetworkSelectors(peer.NetworkSelector, peerPath.Child("networkSelector"))...)
+    if peer.IPBlock != nil {
+      allErrs = append(allErrs, validateIpBlock(peer.IPBlock, peerPath.Child("ipBlock"))...)
+    }
+  }
+  return allErrs
+}
+
+func validateNetworkSelectors(networkSelectors []polv1.NetworkSelector, selectorsPath *field.Path) field.ErrorList {
+  allErrs := field.ErrorList{}
+  for i, networkSelector := range networkSelectors {
+    if networkSelector.Name == "" {
+      allErrs = append(allErrs, field.Required(selectorsPath.Index(i).Child("name"), "network name is mandatory"))
+    }
+    if networkSelector.Type != "" && networkSelector.Type != poltypes.DanmNetKind &&
+       networkSelector.Type != poltypes.ClusterNetworkKind && networkSelector.Type != poltypes.TenantNetworkKind {
+      allErrs = append(allErrs, field.NotSupported(selectorsPath.Index(i).Child("type"), networkSelector.Type,
+        []string{poltypes.DanmNetKind, poltypes.ClusterNetworkKind, poltypes.TenantNetworkKind}))
+    }
+  }
+  return allErrs
+}
+
+func validateIpBlock(ipBlock *polv1.IPBlock, ipBlockPath *field.Path) field.ErrorList {
+  allErrs := field.ErrorList{}
+  cidrIp, cidrNet, err := net.ParseCIDR(ipBlock.CIDR)
+  if err != nil {
+    return append(allErrs, field.Invalid(ipBlockPath.Child("cidr"), ipBlock.CIDR, err.Error()))
+  }
+  for i, except := range ipBlock.Except {
+    exceptIp, exceptNet, err := net.ParseCIDR(except)
+    if err != nil {
+      allErrs = append(allErrs, field.Invalid(ipBlockPath.Child("except").Index(i), except, err.Error()))
+      continue
+    }
+    exceptOnes, _ := exceptNet.Mask.Size()
+    cidrOnes, _ := cidrNet.Mask.Size()
+    if (exceptIp.To4() != nil) != (cidrIp.To4() != nil) || !cidrNet.Contains(exceptIp) || exceptOnes < cidrOnes {
+      allErrs = append(allErrs, field.Invalid(ipBlockPath.Child("except").Index(i), except, "must be a range within the CIDR of the ipBlock"))
+    }
+  }
+  return allErrs
+}
+
+//ValidateNetworkReferences checks whether the networks referenced by the network selectors of a policy exist
+//Namespaced networks can only be checked when they have to be in the namespace of the policy, i.e. when the peer does not have a namespaceSelector
+func ValidateNetworkReferences(spec polv1.NetPolSpec, namespace string, danmClient danmclientset.Interface, specPath *field.Path) field.ErrorList {
+  allErrs := field.ErrorList{}
+  allErrs = append(allErrs, validateNetworksExist(spec.TargetNetworkSelector, namespace, danmClient, specPath.Child("targetNetworkSelector"))...)
+  for i, ingressRule := range spec.Ingress {
+    allErrs = append(allErrs, validatePeerNetworksExist(ingressRule.From, namespace, danmClient, specPath.Child("ingress").Index(i).Child("from"))...)
+  }
+  for i, egressRule := range spec.Egress {
+    allErrs = append(allErrs, validatePeerNetworksExist(egressRule.To, namespace, danmClient, specPath.Child("egress").Index(i).Child("to"))...)
+  }
+  return allErrs
+}
+
+func validatePeerNetworksExist(peers []polv1.NetworkPolicyPeer, namespace string, danmClient danmclientset.Interface, peersPath *field.Path) field.ErrorList {
+  allErrs := field.ErrorList{}
+  for i, peer := range peers {
+    networkNamespace := namespace
+    //The network selector of an ipBlock refers to the networks of the isolated Pod, so it is always in the namespace of the policy
+    if peer.NamespaceSelector != nil && peer.IPBlock == nil {
+      networkNamespace = ""
+    }
+    allErrs = append(allErrs, validateNetworksExist(peer.NetworkSelector, networkNamespace, danmClient, peersPath.Index(i).Child("networkSelector"))...)
+  }
+  return allErrs
+}
+
+//validateNetworksExist looks-up the selected networks. Namespaced networks are only looked-up when the namespace is known
+func validateNetworksExist(networkSelectors []polv1.NetworkSelector, namespace string, danmClient danmclientset.Interface, selectorsPath *field.Path) field.ErrorList {
+  allErrs := field.ErrorList{}
+  for i, networkSelector := range networkSelectors {
+    if networkSelector.Name == "" {
+      continue
+    }
+    var err error
+    switch networkSelector.Type {
+    case "", poltypes.DanmNetKind:
+      if namespace == "" {
+        continue
+      }
+      _, err = danmClient.DanmV1().DanmNets(namespace).Get(context.TODO(), networkSelector.Name, metav1.GetOptions{})
+    case poltypes.TenantNetworkKind:
+      if namespace == "" {
+        continue
+      }
+      _, err = danmClient.DanmV1().TenantNetworks(namespace).Get(context.TODO(), networkSelector.Name, metav1.GetOptions{})
+    case poltypes.ClusterNetworkKind:
+      _, err = danmClient.DanmV1().ClusterNetworks().Get(context.TODO(), networkSelector.Name, metav1.GetOptions{})
+    default:
+      continue
+    }
+    if apierrors.IsNotFound(err) {
+      allErrs = append(allErrs, field.NotFound(selectorsPath.Index(i), networkSelector.Name))
+    } else if err != nil {
+      allErrs = append(allErrs, field.InternalError(selectorsPath.Index(i), err))
+    }
+  }
+  return allErrs
+}
\ No newline at end of file
@@ -0,0 +1,89 @@
+package validation
+
+import (
+  "testing"
+  polv1 "github.com/nokia/danm-utils/crd/api/netpol/v1"
+  corev1 "k8s.io/api/core/v1"
+  metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+  "k8s.io/apimachinery/pkg/util/intstr"
+  "k8s.io/apimachinery/pkg/util/validation/field"
+  "k8s.io/kubernetes/pkg/apis/networking"
+)
+
+var validateNetPolSpecTcs = []struct {
+  tcName string
+  spec polv1.NetPolSpec
+  expectedFields []string
+}{
+  {"valid", polv1.NetPolSpec {
+    PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+    Ingress: []polv1.NetworkPolicyIngressRule{{
+      From: []polv1.NetworkPolicyPeer{{IPBlock: &polv1.IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16"}}}},
+      Ports: []polv1.NetworkPolicyPort{{Port: newIntPort(10000), EndPort: newEndPort(20000)}},
+    }},
+  }, []string{}},
+  {"invalidPodSelector", polv1.NetPolSpec {
+    PodSelector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Equals"}}},
+  }, []string{"spec.podSelector"}},
+  {"invalidPeerSelectors", polv1.NetPolSpec {
+    Egress: []polv1.NetworkPolicyEgressRule{{To: []polv1.NetworkPolicyPeer {
+      {NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"invalid key!": "a"}}},
+      {NetworkSelector: []polv1.NetworkSelector{{Type: "ClusterNetwork"}}},
+    }}},
+  }, []string{"spec.egress[0].to[0].namespaceSelector", "spec.egress[0].to[1].networkSelector[0].name"}},
+  {"invalidPorts", polv1.NetPolSpec {
+    Ingress: []polv1.NetworkPolicyIngressRule{{Ports: []polv1.NetworkPolicyPort {
+      {Port: newIntPort(0)},
+      {Port: newIntPort(2000), EndPort: newEndPort(1000)},
+      {Port: &intstr.IntOrString{Type: intstr.String, StrVal: "http"}, EndPort: newEndPort(1000)},
+    }}},
+  }, []string{"spec.ingress[0].ports[0].port", "spec.ingress[0].ports[1].endPort", "spec.ingress[0].ports[2].endPort"}},
+  {"invalidIpBlock", polv1.NetPolSpec {
+    Ingress: []polv1.NetworkPolicyIngressRule{{From: []polv1.NetworkPolicyPeer {
+      {IPBlock: &polv1.IPBlock{CIDR: "10.0.0.0"}},
+      {IPBlock: &polv1.IPBlock{CIDR: "10.0.0.0/16", Except: []string{"10.1.0.0/24", "10.0.0.0/8", "fd00::/64"}}},
+    }}},
+  }, []string{"spec.ingress[0].from[0].ipBlock.cidr", "spec.ingress[0].from[1].ipBlock.except[0]", "spec.ingress[0].from[1].ipBlock.except[1]", "spec.ingress[0].from[1].ipBlock.except[2]"}},
+  {"denyWithExcept", polv1.NetPolSpec {
+    Egress: []polv1.NetworkPolicyEgressRule{{Action: polv1.RuleActionDeny, To: []polv1.NetworkPolicyPeer {
+      {IPBlock: &polv1.IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16"}}},
+    }}},
+  }, []string{}},
+  {"invalidProtocolAndNetworkType", polv1.NetPolSpec {
+    Ingress: []polv1.NetworkPolicyIngressRule{{Ports: []polv1.NetworkPolicyPort{{Protocol: newProtocol("ICMP")}}}},
+    TargetNetworkSelector: []polv1.NetworkSelector{{Name: "oam", Type: "Network"}, {Name: "internal", Type: "TenantNetwork"}},
+  }, []string{"spec.ingress[0].ports[0].protocol", "spec.targetNetworkSelector[0].type"}},
+  {"invalidActionAndPolicyType", polv1.NetPolSpec {
+    Ingress: []polv1.NetworkPolicyIngressRule{{Action: "Drop"}},
+    PolicyTypes: []networking.PolicyType{"Forward"},
+  }, []string{"spec.ingress[0].action", "spec.policyTypes[0]"}},
+}
+
+func TestValidateNetPolSpec(t *testing.T) {
+  for _, tc := range validateNetPolSpecTcs {
+    t.Run(tc.tcName, func(t *testing.T) {
+      errs := ValidateNetPolSpec(tc.spec, field.NewPath("spec"))
+      if len(errs) != len(tc.expectedFields) {
+        t.Fatalf("Expected errors for fields:%v, but we got:%v", tc.expectedFields, errs)
+      }
+      for i, err := range errs {
+        if err.Field != tc.expectedFields[i] {
+          t.Errorf("Expected error for field:%s, but we got:%v", tc.expectedFields[i], err)
+        }
+      }
+    })
+  }
+}
+
+func newIntPort(port int) *intstr.IntOrString {
+  intPort := intstr.FromInt(port)
+  return &intPort
+}
+
+func newProtocol(protocol corev1.Protocol) *corev1.Protocol {
+  return &protocol
+}
+
+func newEndPort(port int32) *int32 {
+  return &port
+}
\ No newline at end of file
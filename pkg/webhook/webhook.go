@@ -0,0 +1,97 @@
+package webhook
+
+import (
+  "encoding/json"
+  "errors"
+  "io/ioutil"
+  "log"
+  "net/http"
+  danmclientset "github.com/nokia/danm/crd/client/clientset/versioned"
+  polv1 "github.com/nokia/danm-utils/crd/api/netpol/v1"
+  "github.com/nokia/danm-utils/pkg/validation"
+  admissionv1 "k8s.io/api/admission/v1"
+  apierrors "k8s.io/apimachinery/pkg/api/errors"
+  metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+  "k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+  DanmNetworkPolicyKind = "DanmNetworkPolicy"
+)
+
+//Validator admits DanmNetworkPolicies only if the Policer can understand them, and all the networks they reference exist
+type Validator struct {
+  DanmClient danmclientset.Interface
+}
+
+func NewValidator(danmClient danmclientset.Interface) *Validator {
+  return &Validator{DanmClient: danmClient}
+}
+
+//ServeHTTP handles the AdmissionReviews sent by the API server for the create, and update operations of DanmNetworkPolicies
+func (validator *Validator) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+  review, err := decodeAdmissionReview(request)
+  if err != nil {
+    log.Println("ERROR: admission request could not be decoded because:" + err.Error())
+    http.Error(writer, err.Error(), http.StatusBadRequest)
+    return
+  }
+  review.Response = validator.ValidateAdmissionRequest(review.Request)
+  review.Response.UID = review.Request.UID
+  responseBytes, err := json.Marshal(review)
+  if err != nil {
+    log.Println("ERROR: admission response could not be encoded because:" + err.Error())
+    http.Error(writer, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  writer.Header().Set("Content-Type", "application/json")
+  writer.Write(responseBytes)
+}
+
+func decodeAdmissionReview(request *http.Request) (*admissionv1.AdmissionReview, error) {
+  if request.Body == nil {
+    return nil, errors.New("admission request has no body")
+  }
+  body, err := ioutil.ReadAll(request.Body)
+  if err != nil {
+    return nil, errors.New("admission request body could not be read because:" + err.Error())
+  }
+  var review admissionv1.AdmissionReview
+  err = json.Unmarshal(body, &review)
+  if err != nil {
+    return nil, errors.New("admission request body is not an AdmissionReview because:" + err.Error())
+  }
+  if review.Request == nil {
+    return nil, errors.New("AdmissionReview does not contain a request")
+  }
+  return &review, nil
+}
+
+//ValidateAdmissionRequest rejects the DanmNetworkPolicies having any invalid attributes, listing all the problems found
+func (validator *Validator) ValidateAdmissionRequest(request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+  if request.Operation != admissionv1.Create && request.Operation != admissionv1.Update {
+    return &admissionv1.AdmissionResponse{Allowed: true}
+  }
+  var policy polv1.DanmNetworkPolicy
+  err := json.Unmarshal(request.Object.Raw, &policy)
+  if err != nil {
+    return &admissionv1.AdmissionResponse{Result: &apierrors.NewBadRequest("object is not a DanmNetworkPolicy:" + err.Error()).ErrStatus}
+  }
+  if policy.ObjectMeta.Namespace == "" {
+    policy.ObjectMeta.Namespace = request.Namespace
+  }
+  allErrs := validator.ValidatePolicy(&policy)
+  if len(allErrs) > 0 {
+    log.Println("INFO: DanmNetworkPolicy:" + policy.ObjectMeta.Name + " in namespace:" + policy.ObjectMeta.Namespace + " is rejected because:" + allErrs.ToAggregate().Error())
+    return &admissionv1.AdmissionResponse{Result: &apierrors.NewInvalid(polv1.Kind(DanmNetworkPolicyKind), policy.ObjectMeta.Name, allErrs).ErrStatus}
+  }
+  return &admissionv1.AdmissionResponse{Allowed: true, Result: &metav1.Status{Status: metav1.StatusSuccess}}
+}
+
+//ValidatePolicy returns both the syntactic problems of the policy, and the networks it references which do not exist
+func (validator *Validator) ValidatePolicy(policy *polv1.DanmNetworkPolicy) field.ErrorList {
+  specPath := field.NewPath("spec")
+  allErrs := validation.ValidateNetPolSpec(policy.Spec, specPath)
+  allErrs = append(allErrs, validation.ValidateNetworkReferences(policy.Spec, policy.ObjectMeta.Namespace, validator.DanmClient, specPath)...)
+  return allErrs
+}
\ No newline at end of file
@@ -0,0 +1,88 @@
+package webhook
+
+import (
+  "bytes"
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  danmv1 "github.com/nokia/danm/crd/apis/danm/v1"
+  danmfake "github.com/nokia/danm/crd/client/clientset/versioned/fake"
+  polv1 "github.com/nokia/danm-utils/crd/api/netpol/v1"
+  admissionv1 "k8s.io/api/admission/v1"
+  metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+  "k8s.io/apimachinery/pkg/runtime"
+  "k8s.io/apimachinery/pkg/types"
+)
+
+const (
+  testNamespace = "default"
+)
+
+var (
+  testNetworks = []runtime.Object {
+    &danmv1.DanmNet{ObjectMeta: metav1.ObjectMeta{Name: "internal", Namespace: testNamespace}},
+    &danmv1.ClusterNetwork{ObjectMeta: metav1.ObjectMeta{Name: "oam"}},
+  }
+)
+
+var validatePolicyTcs = []struct {
+  tcName string
+  operation admissionv1.Operation
+  networkSelectors []polv1.NetworkSelector
+  isAllowed bool
+  expectedCauses []string
+}{
+  {"existingNetworks", admissionv1.Create, []polv1.NetworkSelector{{Name: "internal"}, {Name: "oam", Type: "ClusterNetwork"}}, true, nil},
+  {"missingNetwork", admissionv1.Update, []polv1.NetworkSelector{{Name: "oam"}}, false, []string{"spec.ingress[0].from[0].networkSelector[0]"}},
+  {"invalidNetworkType", admissionv1.Create, []polv1.NetworkSelector{{Name: "oam", Type: "Network"}}, false, []string{"spec.ingress[0].from[0].networkSelector[0].type"}},
+  {"deleteIsNotValidated", admissionv1.Delete, []polv1.NetworkSelector{{Name: "oam"}}, true, nil},
+}
+
+func TestServeHTTP(t *testing.T) {
+  validator := NewValidator(danmfake.NewSimpleClientset(testNetworks...))
+  for _, tc := range validatePolicyTcs {
+    t.Run(tc.tcName, func(t *testing.T) {
+      policy := polv1.DanmNetworkPolicy {
+        ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testNamespace},
+        Spec: polv1.NetPolSpec{Ingress: []polv1.NetworkPolicyIngressRule{{From: []polv1.NetworkPolicyPeer{{NetworkSelector: tc.networkSelectors}}}}},
+      }
+      policyBytes, _ := json.Marshal(policy)
+      review := admissionv1.AdmissionReview {
+        TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+        Request: &admissionv1.AdmissionRequest{UID: types.UID("review"), Operation: tc.operation, Namespace: testNamespace, Object: runtime.RawExtension{Raw: policyBytes}},
+      }
+      reviewBytes, _ := json.Marshal(review)
+      recorder := httptest.NewRecorder()
+      validator.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(reviewBytes)))
+      var response admissionv1.AdmissionReview
+      err := json.Unmarshal(recorder.Body.Bytes(), &response)
+      if err != nil || response.Response == nil {
+        t.Fatalf("AdmissionReview response could not be decoded from:%s", recorder.Body.String())
+      }
+      if response.Response.UID != review.Request.UID || response.Response.Allowed != tc.isAllowed {
+        t.Fatalf("Expected allowed:%t for review:%s, but we got:%+v", tc.isAllowed, review.Request.UID, response.Response)
+      }
+      if tc.isAllowed {
+        return
+      }
+      causes := response.Response.Result.Details.Causes
+      if len(causes) != len(tc.expectedCauses) {
+        t.Fatalf("Expected causes for fields:%v, but we got:%v", tc.expectedCauses, causes)
+      }
+      for i, cause := range causes {
+        if cause.Field != tc.expectedCauses[i] {
+          t.Errorf("Expected cause for field:%s, but we got:%v", tc.expectedCauses[i], cause)
+        }
+      }
+    })
+  }
+}
+
+func TestServeHTTPInvalidBody(t *testing.T) {
+  recorder := httptest.NewRecorder()
+  NewValidator(danmfake.NewSimpleClientset()).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader([]byte("{"))))
+  if recorder.Code != http.StatusBadRequest {
+    t.Errorf("Expected status code:%d for an invalid body, but we got:%d", http.StatusBadRequest, recorder.Code)
+  }
+}
\ No newline at end of file
@@ -0,0 +1,30 @@
+#!/bin/sh -ex
+# Regenerates the deepcopy functions, the clientset, the listers and the informers of the Policer APIs
+# The generators are built from the k8s.io/code-generator version pinned in go.mod, so the output only changes together with it
+# The API group of the generated code comes from the +groupName tag in crd/api/netpol/v1/doc.go
+REPO_ROOT="$(cd "$(dirname "$0")/../.." && pwd)"
+API_PKG="github.com/nokia/danm-utils/crd/api"
+CLIENT_PKG="github.com/nokia/danm-utils/crd/client"
+TMP_DIR="$(mktemp -d)"
+trap 'rm -rf "${TMP_DIR}"' EXIT
+cd "${REPO_ROOT}"
+CODEGEN_DIR="$(go list -m -f '{{.Dir}}' k8s.io/code-generator)"
+for generator in deepcopy-gen client-gen lister-gen informer-gen; do
+  go build -o "${TMP_DIR}/bin/${generator}" k8s.io/code-generator/cmd/${generator}
+done
+HEADER="${CODEGEN_DIR}/hack/boilerplate.go.txt"
+OUT="${TMP_DIR}/out"
+
+"${TMP_DIR}/bin/deepcopy-gen" --input-dirs "${API_PKG}/netpol/v1" -O zz_generated.deepcopy --bounding-dirs "${API_PKG}" \
+  --output-base "${OUT}" --go-header-file "${HEADER}"
+"${TMP_DIR}/bin/client-gen" --clientset-name versioned --input-base "${API_PKG}" --input netpol/v1 \
+  --output-package "${CLIENT_PKG}/clientset" --output-base "${OUT}" --go-header-file "${HEADER}"
+"${TMP_DIR}/bin/lister-gen" --input-dirs "${API_PKG}/netpol/v1" \
+  --output-package "${CLIENT_PKG}/listers" --output-base "${OUT}" --go-header-file "${HEADER}"
+"${TMP_DIR}/bin/informer-gen" --input-dirs "${API_PKG}/netpol/v1" \
+  --versioned-clientset-package "${CLIENT_PKG}/clientset/versioned" --listers-package "${CLIENT_PKG}/listers" \
+  --output-package "${CLIENT_PKG}/informers" --output-base "${OUT}" --go-header-file "${HEADER}"
+
+cp "${OUT}/${API_PKG}/netpol/v1/zz_generated.deepcopy.go" crd/api/netpol/v1/
+rm -rf crd/client
+cp -r "${OUT}/${CLIENT_PKG}" crd/client
@@ -1,14 +1,18 @@
 package poltypes
 
 import (
+  "crypto/sha256"
+  "encoding/hex"
+  "strings"
   danmv1 "github.com/nokia/danm/crd/apis/danm/v1"
   corev1 "k8s.io/api/core/v1"
   "k8s.io/apimachinery/pkg/types"
 )
 
 const (
-  DefaultBucketName   = "default"
-  CustomBucketPostfix = "bucket42"
+  DefaultBucketName    = "default"
+  ExpressionBucketName = "expression"
+  CustomBucketPostfix  = "bucket42"
   IptablesReject = "REJECT"
   IptablesAccept = "ACCEPT"
   IptablesReturn = "RETURN"
@@ -16,37 +20,142 @@ const (
   IngressV6ChainName = "DANM_INGRESS_V6"
   EgressV4ChainName = "DANM_EGRESS_V4"
   EgressV6ChainName = "DANM_EGRESS_V6"
+  //ExceptChainInfix joins the name of a chain, and the index of its sub-chain handling the except ranges of an ipBlock
+  ExceptChainInfix = "_EXCEPT_"
+  StateNew = "NEW"
   StateEstablishedRelated = "ESTABLISHED,RELATED"
   StateNewEstablished = "NEW,ESTABLISHED"
+  PortRangeSeparator = ":"
   DanmNetKind  = "DanmNet"
   ClusterNetworkKind = "ClusterNetwork"
+  TenantNetworkKind = "TenantNetwork"
+  //MirrorNetworkPoliciesAnnotation opts a namespace into the enforcement of its upstream NetworkPolicies by the Policer
+  MirrorNetworkPoliciesAnnotation = "danm.k8s.io/mirror-network-policies"
+  //FingerprintPrefix starts the comment of the marker rule provisioned into isolated Pods, identifying the rules as Policer provisioned
+  FingerprintPrefix = "danm-policer:"
 )
 
+//Default rules are provisioned by every backend into isolated Pods on top of the rules coming from policies
+var (
+  DefaultIngressRules = []NetRule {
+    //Allow localhost communication by default
+    NetRule{SourceIface: "lo", Operation: IptablesAccept,},
+    //Allow bi-directional communication through connections already established with trusted Egress entities
+    NetRule{State: StateEstablishedRelated, Operation: IptablesAccept,},
+    NetRule{Operation: IptablesReject,},
+  }
+  DefaultEgressRules = []NetRule {
+    //Allow localhost communication by default
+    NetRule{DestIface: "lo", Operation: IptablesAccept,},
+    //Allow bi-directional communication through connections already established with trusted Ingress entities
+    NetRule{State: StateEstablishedRelated, Operation: IptablesAccept,},
+    //Allow outgoing cluster DNS communication by default. Can happen via either TCP, or UDP
+    NetRule{Protocol: "tcp", DestPort: "53", State: StateNewEstablished, Operation: IptablesAccept,},
+    NetRule{Protocol: "udp", DestPort: "53", State: StateNewEstablished, Operation: IptablesAccept,},
+    NetRule{Operation: IptablesReject,},
+  }
+  DefaultForwardRules = []NetRule {
+    NetRule{Operation: IptablesReject,},
+  }
+)
+
+//RuleProvisioner is the contract every rule executor backend needs to fulfill
+//Both operations are expected to be idempotent, as they are repeated for the same Pod whenever its policies, or peers change
 type RuleProvisioner interface {
-  AddRulesToPod(*NetRuleSet,*corev1.Pod)
+  //AddRulesToPod isolates the Pod, replacing all its previously provisioned rules with the ones in the NetRuleSet
+  AddRulesToPod(*NetRuleSet,*corev1.Pod) error
+  //RemoveRulesFromPod removes all isolation from a Pod not selected by any policies anymore
+  RemoveRulesFromPod(*NetRuleSet,*corev1.Pod) error
+  //IsRuleSetProvisioned reads the rules currently present in the Pod, and tells whether they are the ones AddRulesToPod would provision from the NetRuleSet
+  //A NetRuleSet not isolating the Pod in any direction is provisioned when no Policer created rules are found in the Pod
+  IsRuleSetProvisioned(*NetRuleSet,*corev1.Pod) (bool,error)
+}
+
+//NewFingerprint identifies a rendered rule payload, and is provisioned into the Pod together with the payload
+//Comparing the fingerprint found in a Pod with the one of a freshly rendered payload tells whether the Pod still has the same rules
+func NewFingerprint(payload []byte) string {
+  hash := sha256.Sum256(payload)
+  return FingerprintPrefix + hex.EncodeToString(hash[:8])
+}
+
+//FindFingerprint returns the fingerprint found in a rendered payload, or in the rules read from a Pod. Empty string is returned when there is none
+func FindFingerprint(rules []byte) string {
+  for _, field := range strings.Fields(string(rules)) {
+    //Rule listing tools might, or might not quote the comments
+    field = strings.Trim(field, "\"")
+    if strings.HasPrefix(field, FingerprintPrefix) {
+      return field
+    }
+  }
+  return ""
 }
 
 type UidCache map[types.UID]bool
 
-type DanmEpBuckets map[string][]danmv1.DanmEp
+//DanmEpBuckets sorts DanmEps into named buckets, e.g. by their labels, or networks
+type DanmEpBuckets interface {
+  //Get returns the DanmEps of the bucket
+  Get(bucket string) []danmv1.DanmEp
+  //List returns all the DanmEps sorted into any of the buckets, each of them once
+  List() []danmv1.DanmEp
+}
+
+//DanmEpMap is a DanmEpBuckets holding a fixed set of DanmEps
+type DanmEpMap map[string][]danmv1.DanmEp
+
+func (depMap DanmEpMap) Get(bucket string) []danmv1.DanmEp {
+  return depMap[bucket]
+}
+
+func (depMap DanmEpMap) List() []danmv1.DanmEp {
+  deps := make([]danmv1.DanmEp, 0)
+  depUidCache := make(UidCache, 0)
+  for _, bucket := range depMap {
+    for _, dep := range bucket {
+      if _, ok := depUidCache[dep.ObjectMeta.UID]; !ok {
+        depUidCache[dep.ObjectMeta.UID] = true
+        deps = append(deps, dep)
+      }
+    }
+  }
+  return deps
+}
 
 type DanmEpSet struct {
-  DanmEpsByLabel   DanmEpBuckets
-  DanmEpsByNetwork DanmEpBuckets
+  DanmEpsByLabel     DanmEpBuckets
+  DanmEpsByNetwork   DanmEpBuckets
+  DanmEpsByNamespace DanmEpBuckets
+  //Labels of the namespaces the DanmEps were gathered from, used to evaluate namespace selectors
+  NamespaceLabels    map[string]map[string]string
   PodEps  []danmv1.DanmEp
 }
 
 type NetRuleSet struct {
-  IngressV4Chain NetRuleChain
-  IngressV6Chain NetRuleChain
-  EgressV4Chain  NetRuleChain
-  EgressV6Chain  NetRuleChain
-  Netns          string
+  IngressV4Chain    NetRuleChain
+  IngressV6Chain    NetRuleChain
+  EgressV4Chain     NetRuleChain
+  EgressV6Chain     NetRuleChain
+  Netns             string
+  //Traffic of a direction is only restricted when the Pod is selected by any policy of that type
+  IsIngressIsolated bool
+  IsEgressIsolated  bool
+  //Interfaces of the Pod isolated in the given direction. Empty list means all the interfaces are isolated
+  IngressIfaces     []string
+  EgressIfaces      []string
 }
 
 type NetRuleChain struct {
   Name string
   Rules []NetRule
+  //SubChains are jumped to from the rules of the chain. A RETURN rule of a sub-chain only ends the evaluation of the sub-chain, not the one of the whole chain
+  SubChains []NetRuleChain
+}
+
+//NetPort is a port of a policy, already resolved to a number against the container ports of the Pod it refers to
+//Empty Port means all the ports of the protocol, while port ranges are represented by their first and last port separated by PortRangeSeparator
+type NetPort struct {
+  Protocol string
+  Port     string
 }
 
 type NetRule struct {
@@ -73,4 +182,37 @@ func (rule NetRule) String() string {
   if rule.Operation   != "" {ruleStr += " op:" + rule.Operation}
   if rule.State       != "" {ruleStr += " state:" + rule.State}
   return ruleStr
+}
+
+//RestrictToIfaces returns a copy of the rule for every interface, matching on the incoming interface for ingress, and on the outgoing interface for egress rules
+//Empty interface list means all interfaces, so the rule is returned as is
+func RestrictToIfaces(rule NetRule, ifaces []string, isIngress bool) []NetRule {
+  if len(ifaces) == 0 {
+    return []NetRule{rule}
+  }
+  rules := make([]NetRule, 0)
+  for _, iface := range ifaces {
+    ifaceRule := rule
+    if isIngress {
+      ifaceRule.SourceIface = iface
+    } else {
+      ifaceRule.DestIface = iface
+    }
+    rules = append(rules, ifaceRule)
+  }
+  return rules
+}
+
+//RestrictDefaultRules restricts the REJECT rules of the default rules to the isolated interfaces
+//The other default rules only accept traffic, so they can be safely left unrestricted
+func RestrictDefaultRules(defaultRules []NetRule, ifaces []string, isIngress bool) []NetRule {
+  rules := make([]NetRule, 0)
+  for _, rule := range defaultRules {
+    if rule.Operation == IptablesReject {
+      rules = append(rules, RestrictToIfaces(rule, ifaces, isIngress)...)
+    } else {
+      rules = append(rules, rule)
+    }
+  }
+  return rules
 }
\ No newline at end of file